@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// AccessGrant records a user who joined with an invite code.
+type AccessGrant struct {
+	Granted time.Time `json:"granted"`
+}
+
+// restricted reports whether the bot is limited to known users at all.
+// Without allowlists, invite codes or private mode everyone may use it.
+func (c *Config) restricted() bool {
+	return len(c.AllowedUsers) > 0 || len(c.AllowedChats) > 0 || len(c.InviteCodes) > 0 || c.PrivateMode
+}
+
+// allowUpdate is the access check in front of all handlers. Blocked users
+// and chats are always ignored; strangers are told how to get access, or
+// silently ignored in private mode, and may redeem an invite code.
+func (b *Bot) allowUpdate(update *tgbotapi.Update) bool {
+	var userID, chatID int64
+	if user := update.SentFrom(); user != nil {
+		userID = user.ID
+	}
+	if chat := update.FromChat(); chat != nil {
+		chatID = chat.ID
+	}
+
+	if b.isBlocked(userID, chatID) {
+		log.Printf("Ignoring update from blocked user %d in chat %d", userID, chatID)
+		return false
+	}
+	if b.hasAccess(userID, chatID) {
+		return true
+	}
+
+	// Strangers may still join with an invite code: /start <code>
+	if msg := update.Message; msg != nil && msg.IsCommand() && msg.Command() == "start" && msg.CommandArguments() != "" {
+		b.redeemInvite(msg, userID)
+		return false
+	}
+	if b.config().PrivateMode {
+		log.Printf("Ignoring update from stranger %d in chat %d", userID, chatID)
+		return false
+	}
+
+	const denied = "🔒 This bot is private. If you have an invite code, send /start <code>."
+	switch {
+	case update.CallbackQuery != nil:
+		b.api.Request(tgbotapi.NewCallbackWithAlert(update.CallbackQuery.ID, denied))
+	case update.Message != nil:
+		b.api.Send(tgbotapi.NewMessage(update.Message.Chat.ID, denied))
+	}
+	return false
+}
+
+func (b *Bot) isBlocked(userID, chatID int64) bool {
+	cfg := b.config()
+	if containsID(cfg.AdminIDs, userID) {
+		return false
+	}
+	if containsID(cfg.BlockedUsers, userID) || containsID(cfg.BlockedChats, chatID) {
+		return true
+	}
+	// Users banned with /ban
+	var ban BanRecord
+	found, err := b.store.Get(bucketBans, strconv.FormatInt(userID, 10), &ban)
+	if err != nil {
+		log.Printf("Failed to load ban for user %d: %v", userID, err)
+	}
+	return found
+}
+
+func (b *Bot) hasAccess(userID, chatID int64) bool {
+	if !b.config().restricted() {
+		return true
+	}
+	if containsID(b.config().AdminIDs, userID) || containsID(b.config().AllowedUsers, userID) || containsID(b.config().AllowedChats, chatID) {
+		return true
+	}
+	var grant AccessGrant
+	found, err := b.store.Get(bucketAccess, strconv.FormatInt(userID, 10), &grant)
+	if err != nil {
+		log.Printf("Failed to load access for user %d: %v", userID, err)
+	}
+	return found
+}
+
+// redeemInvite grants access to a user who sent a valid invite code.
+func (b *Bot) redeemInvite(msg *tgbotapi.Message, userID int64) {
+	// Guessing codes counts against the normal rate limit
+	if ok, _ := b.limiter.Allow(userID); !ok {
+		return
+	}
+	code := strings.TrimSpace(msg.CommandArguments())
+	if !validInviteCode(b.config().InviteCodes, code) {
+		log.Printf("User %d sent an invalid invite code", userID)
+		if !b.config().PrivateMode {
+			b.api.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ This invite code is not valid."))
+		}
+		return
+	}
+
+	if err := b.store.Put(bucketAccess, strconv.FormatInt(userID, 10), AccessGrant{Granted: time.Now()}, 0); err != nil {
+		log.Printf("Failed to grant access to user %d: %v", userID, err)
+		b.api.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Something went wrong. Please try again."))
+		return
+	}
+	log.Printf("User %d joined with an invite code", userID)
+	b.api.Send(tgbotapi.NewMessage(msg.Chat.ID, "✅ Invite accepted — you now have access."))
+	b.sendWelcomeMessage(msg.Chat.ID)
+}
+
+func validInviteCode(codes []string, code string) bool {
+	valid := false
+	for _, c := range codes {
+		// Compare every code in constant time so timing reveals nothing
+		if subtle.ConstantTimeCompare([]byte(c), []byte(code)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+func containsID(ids []int64, id int64) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
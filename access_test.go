@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func textUpdate(userID int64, text string) tgbotapi.Update {
+	msg := &tgbotapi.Message{
+		MessageID: 1,
+		From:      &tgbotapi.User{ID: userID},
+		Chat:      &tgbotapi.Chat{ID: userID, Type: "private"},
+		Text:      text,
+	}
+	if strings.HasPrefix(text, "/") {
+		cmd := strings.Fields(text)[0]
+		msg.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(cmd)}}
+	}
+	return tgbotapi.Update{Message: msg}
+}
+
+func TestAccessAllowlist(t *testing.T) {
+	b, tg := newTestBot(t, newFakeDownloader())
+	b.config().AllowedUsers = []int64{7}
+
+	b.handleUpdate(textUpdate(7, "hello"))
+	b.handleUpdate(textUpdate(8, "hello"))
+
+	msgs := tg.find("sendMessage")
+	if len(msgs) != 2 {
+		t.Fatalf("got %d messages, want 2", len(msgs))
+	}
+	if got := msgs[0].Params.Get("text"); !strings.Contains(got, "valid YouTube") {
+		t.Errorf("allowed user got %q", got)
+	}
+	if got := msgs[1].Params.Get("text"); !strings.Contains(got, "This bot is private") {
+		t.Errorf("stranger got %q", got)
+	}
+}
+
+func TestAccessPrivateModeAndBlocklist(t *testing.T) {
+	b, tg := newTestBot(t, newFakeDownloader())
+	b.config().AllowedUsers = []int64{7, 9}
+	b.config().BlockedUsers = []int64{9}
+	b.config().PrivateMode = true
+
+	b.handleUpdate(textUpdate(8, "hello"))
+	b.handleUpdate(textUpdate(9, "hello"))
+	b.handleUpdate(tgbotapi.Update{CallbackQuery: callback("help")}) // user 42
+
+	if calls := len(tg.find("sendMessage")) + len(tg.find("answerCallbackQuery")); calls != 0 {
+		t.Errorf("strangers and blocked users must be ignored silently, got %d replies", calls)
+	}
+}
+
+func TestAccessInviteCode(t *testing.T) {
+	b, tg := newTestBot(t, newFakeDownloader())
+	b.config().InviteCodes = []string{"team-2024"}
+
+	b.handleUpdate(textUpdate(8, "/start wrong"))
+	b.handleUpdate(textUpdate(8, "/start team-2024"))
+	b.handleUpdate(textUpdate(8, "hello"))
+
+	var texts []string
+	for _, m := range tg.find("sendMessage") {
+		texts = append(texts, m.Params.Get("text"))
+	}
+	want := []string{"❌ This invite code is not valid.", "✅ Invite accepted — you now have access.", "Please send a valid YouTube video or playlist link."}
+	if strings.Join(texts, "|") != strings.Join(want, "|") {
+		t.Errorf("messages = %q, want %q", texts, want)
+	}
+	if !b.hasAccess(8, 8) {
+		t.Error("invited user should keep access")
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/joho/godotenv"
+)
+
+// BanRecord is a user banned with /ban.
+type BanRecord struct {
+	By     int64     `json:"by"`
+	Banned time.Time `json:"banned"`
+}
+
+// defaultCleanupAge is how old leftover downloads must be for /cleanup to remove them.
+const defaultCleanupAge = time.Hour
+
+func (b *Bot) isAdmin(user *tgbotapi.User) bool {
+	return user != nil && containsID(b.config().AdminIDs, user.ID)
+}
+
+// handleAdminCommand runs an admin-only command. It returns false for
+// commands it does not know, so they fall through to the regular ones.
+func (b *Bot) handleAdminCommand(message *tgbotapi.Message) bool {
+	chatID := message.Chat.ID
+	args := strings.TrimSpace(message.CommandArguments())
+
+	var reply string
+	switch message.Command() {
+	case "stats":
+		reply = b.statsReport()
+	case "queue":
+		reply = b.queueReport()
+	case "ban":
+		reply = b.banUser(message.From.ID, args)
+	case "unban":
+		reply = b.unbanUser(args)
+	case "broadcast":
+		if args == "" {
+			reply = "Usage: /broadcast <message>"
+			break
+		}
+		// Sending to every chat takes a while; report back when done
+		go b.broadcast(chatID, args)
+		return true
+	case "diskusage":
+		reply = b.diskReport()
+	case "cleanup":
+		reply = b.cleanupDownloads(args)
+	case "ytdlpversion":
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		version, err := b.dl.Version(ctx)
+		if err != nil {
+			reply = fmt.Sprintf("❌ %v", err)
+		} else {
+			reply = "yt-dlp " + version
+		}
+	case "reload":
+		reply = b.reloadConfig()
+	default:
+		return false
+	}
+
+	log.Printf("Admin %d ran /%s %s", message.From.ID, message.Command(), args)
+	b.api.Send(tgbotapi.NewMessage(chatID, reply))
+	return true
+}
+
+// statsReport summarizes the job history and caches.
+func (b *Bot) statsReport() string {
+	keys, err := b.store.Keys(bucketJobs)
+	if err != nil {
+		return fmt.Sprintf("❌ Failed to read job history: %v", err)
+	}
+	statuses := make(map[string]int)
+	users := make(map[int64]bool)
+	var lastDay int
+	dayAgo := time.Now().Add(-24 * time.Hour)
+	for _, key := range keys {
+		var rec JobRecord
+		if found, err := b.store.Get(bucketJobs, key, &rec); err != nil || !found {
+			continue
+		}
+		statuses[rec.Status]++
+		users[rec.UserID] = true
+		if rec.Finished.After(dayAgo) {
+			lastDay++
+		}
+	}
+	fileIDs, _ := b.store.Keys(bucketFileIDs)
+	pending, active := b.jobs.Len()
+
+	var sb strings.Builder
+	sb.WriteString("📊 Stats\n\n")
+	fmt.Fprintf(&sb, "Uptime: %s\n", time.Since(b.started).Round(time.Second))
+	fmt.Fprintf(&sb, "Jobs in history: %d (last 24h: %d)\n", len(keys), lastDay)
+	fmt.Fprintf(&sb, "Done: %d • Failed: %d • Cancelled: %d\n", statuses["done"], statuses["failed"], statuses["cancelled"])
+	fmt.Fprintf(&sb, "Users: %d\n", len(users))
+	fmt.Fprintf(&sb, "Queue: %d running, %d waiting\n", active, pending)
+	fmt.Fprintf(&sb, "Cached file_ids: %d", len(fileIDs))
+	return sb.String()
+}
+
+// queueReport lists running and waiting jobs.
+func (b *Bot) queueReport() string {
+	active, pending := b.jobs.Snapshot()
+	if len(active) == 0 && len(pending) == 0 {
+		return "📭 The queue is empty."
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "📋 Queue: %d running, %d waiting\n", len(active), len(pending))
+	for _, job := range active {
+		fmt.Fprintf(&sb, "\n▶️ #%d user %d • %s %s • running %s\n%s\n", job.ID, job.UserID, job.Format, job.Quality, time.Since(job.Started).Round(time.Second), job.URL)
+	}
+	for i, job := range pending {
+		fmt.Fprintf(&sb, "\n🕒 %d. #%d user %d • %s %s • waiting %s\n%s\n", i+1, job.ID, job.UserID, job.Format, job.Quality, time.Since(job.Enqueued).Round(time.Second), job.URL)
+	}
+	return sb.String()
+}
+
+func (b *Bot) banUser(adminID int64, arg string) string {
+	userID, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return "Usage: /ban <user id>"
+	}
+	if containsID(b.config().AdminIDs, userID) {
+		return "❌ Admins can't be banned."
+	}
+	if err := b.store.Put(bucketBans, arg, BanRecord{By: adminID, Banned: time.Now()}, 0); err != nil {
+		return fmt.Sprintf("❌ Failed to ban %d: %v", userID, err)
+	}
+
+	// Stop whatever the user still has queued or running
+	active, pending := b.jobs.Snapshot()
+	cancelled := 0
+	for _, job := range append(active, pending...) {
+		if job.UserID == userID && b.stopJob(&job) {
+			cancelled++
+		}
+	}
+	return fmt.Sprintf("🚫 User %d is banned (%d jobs cancelled).", userID, cancelled)
+}
+
+func (b *Bot) unbanUser(arg string) string {
+	userID, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return "Usage: /unban <user id>"
+	}
+	var ban BanRecord
+	found, _ := b.store.Get(bucketBans, arg, &ban)
+	if found {
+		if err := b.store.Delete(bucketBans, arg); err != nil {
+			return fmt.Sprintf("❌ Failed to unban %d: %v", userID, err)
+		}
+	}
+	if containsID(b.config().BlockedUsers, userID) {
+		return fmt.Sprintf("⚠️ User %d is still blocked by BLOCKED_USERS in the configuration.", userID)
+	}
+	if !found {
+		return fmt.Sprintf("User %d was not banned.", userID)
+	}
+	return fmt.Sprintf("✅ User %d is unbanned.", userID)
+}
+
+// knownChats collects every chat the bot has served or granted access to.
+func (b *Bot) knownChats() []int64 {
+	chats := make(map[int64]bool)
+	keys, _ := b.store.Keys(bucketJobs)
+	for _, key := range keys {
+		var rec JobRecord
+		if found, _ := b.store.Get(bucketJobs, key, &rec); found {
+			chats[rec.ChatID] = true
+		}
+	}
+	// Users who joined with an invite or saved settings talk to us in private chats
+	for _, bucket := range []string{bucketAccess, bucketSettings} {
+		keys, _ := b.store.Keys(bucket)
+		for _, key := range keys {
+			if id, err := strconv.ParseInt(key, 10, 64); err == nil {
+				chats[id] = true
+			}
+		}
+	}
+	ids := make([]int64, 0, len(chats))
+	for id := range chats {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// broadcastInterval keeps broadcasts under Telegram's ~30 messages/second limit.
+const broadcastInterval = 50 * time.Millisecond
+
+func (b *Bot) broadcast(adminChatID int64, text string) {
+	chats := b.knownChats()
+	sent, failed := 0, 0
+	for _, chatID := range chats {
+		if _, err := b.api.Send(tgbotapi.NewMessage(chatID, "📣 "+text)); err != nil {
+			log.Printf("Broadcast to %d failed: %v", chatID, err)
+			failed++
+		} else {
+			sent++
+		}
+		time.Sleep(broadcastInterval)
+	}
+	b.api.Send(tgbotapi.NewMessage(adminChatID, fmt.Sprintf("📣 Broadcast sent to %d chats (%d failed).", sent, failed)))
+}
+
+// diskReport shows how much space downloads and state take, and what is left.
+func (b *Bot) diskReport() string {
+	cfg := b.config()
+	var sb strings.Builder
+	sb.WriteString("💾 Disk usage\n")
+	for _, dir := range []struct{ name, path string }{{"Downloads", cfg.DownloadPath}, {"Data", cfg.DataPath}} {
+		size, files := dirSize(dir.path)
+		fmt.Fprintf(&sb, "\n%s (%s): %s in %d files", dir.name, dir.path, formatBytes(size), files)
+	}
+	if free, err := diskFree(cfg.DownloadPath); err == nil {
+		fmt.Fprintf(&sb, "\nFree space: %s", formatBytes(int64(free)))
+	}
+	return sb.String()
+}
+
+func dirSize(root string) (size int64, files int) {
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+			files++
+		}
+		return nil
+	})
+	return size, files
+}
+
+// cleanupDownloads removes leftover files from the download directory that
+// are older than the given age (default 1h), so running downloads are kept.
+func (b *Bot) cleanupDownloads(arg string) string {
+	age := defaultCleanupAge
+	if arg != "" {
+		d, err := time.ParseDuration(arg)
+		if err != nil || d <= 0 {
+			return "Usage: /cleanup [min age, e.g. 30m]"
+		}
+		age = d
+	}
+
+	entries, err := os.ReadDir(b.config().DownloadPath)
+	if err != nil {
+		return fmt.Sprintf("❌ Failed to read downloads: %v", err)
+	}
+	var freed int64
+	removed := 0
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || e.IsDir() || time.Since(info.ModTime()) < age {
+			continue
+		}
+		if err := os.Remove(filepath.Join(b.config().DownloadPath, e.Name())); err == nil {
+			freed += info.Size()
+			removed++
+		}
+	}
+	return fmt.Sprintf("🧹 Removed %d files older than %s, freed %s.", removed, age, formatBytes(freed))
+}
+
+// reloadConfig re-reads .env and the environment. Settings that are wired
+// into running components at startup keep their old values until a restart.
+func (b *Bot) reloadConfig() string {
+	if err := godotenv.Overload(); err != nil {
+		log.Printf("Reload: no .env file loaded: %v", err)
+	}
+	next, err := loadConfig()
+	if err != nil {
+		return fmt.Sprintf("❌ Reload failed: %v", err)
+	}
+	cur := b.config()
+
+	var restart []string
+	if next.Token != cur.Token {
+		restart = append(restart, "TELEGRAM_BOT_TOKEN")
+	}
+	if next.DownloadPath != cur.DownloadPath {
+		restart = append(restart, "DOWNLOAD_PATH")
+	}
+	if next.DataPath != cur.DataPath {
+		restart = append(restart, "DATA_PATH")
+	}
+	if next.Workers != cur.Workers {
+		restart = append(restart, "DOWNLOAD_WORKERS")
+	}
+	if next.QueueSize != cur.QueueSize {
+		restart = append(restart, "DOWNLOAD_QUEUE_SIZE")
+	}
+	if next.BotAPIURL != cur.BotAPIURL {
+		restart = append(restart, "TELEGRAM_API_URL")
+	}
+	next.Token, next.DownloadPath, next.DataPath = cur.Token, cur.DownloadPath, cur.DataPath
+	next.Workers, next.QueueSize, next.BotAPIURL = cur.Workers, cur.QueueSize, cur.BotAPIURL
+
+	b.cfg.Store(next)
+	b.limiter.SetLimit(next.RateLimitPerMinute)
+	log.Printf("Configuration reloaded")
+
+	reply := "🔄 Configuration reloaded."
+	if len(restart) > 0 {
+		reply += "\nRestart needed to apply: " + strings.Join(restart, ", ")
+	}
+	return reply
+}
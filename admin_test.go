@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestAdminCommandsRequireAdmin(t *testing.T) {
+	b, tg := newTestBot(t, newFakeDownloader())
+	b.config().AdminIDs = []int64{1}
+
+	b.handleUpdate(textUpdate(2, "/stats"))
+	b.handleUpdate(textUpdate(1, "/stats"))
+
+	msgs := tg.find("sendMessage")
+	if len(msgs) != 2 {
+		t.Fatalf("got %d messages, want 2", len(msgs))
+	}
+	if got := msgs[0].Params.Get("text"); !strings.HasPrefix(got, "Unknown command") {
+		t.Errorf("non-admin got %q", got)
+	}
+	if got := msgs[1].Params.Get("text"); !strings.HasPrefix(got, "📊 Stats") {
+		t.Errorf("admin got %q", got)
+	}
+}
+
+func TestAdminBanAndUnban(t *testing.T) {
+	b, tg := newTestBot(t, newFakeDownloader())
+	b.config().AdminIDs = []int64{1}
+
+	b.handleUpdate(textUpdate(1, "/ban 5"))
+	b.handleUpdate(textUpdate(5, "hello"))
+	b.handleUpdate(textUpdate(1, "/unban 5"))
+	b.handleUpdate(textUpdate(5, "hello"))
+
+	var texts []string
+	for _, m := range tg.find("sendMessage") {
+		texts = append(texts, m.Params.Get("text"))
+	}
+	want := []string{
+		"🚫 User 5 is banned (0 jobs cancelled).",
+		"✅ User 5 is unbanned.",
+		"Please send a valid YouTube video or playlist link.",
+	}
+	if strings.Join(texts, "|") != strings.Join(want, "|") {
+		t.Errorf("messages = %q, want %q", texts, want)
+	}
+}
+
+func TestAdminBanCancelsQueuedJobs(t *testing.T) {
+	dl := newFakeDownloader()
+	busy := "https://youtu.be/fffffffffff"
+	dl.addVideo(busy, "fffffffffff", "Long Talk")
+	started := dl.blockFetch(busy)
+	link := "https://youtu.be/ggggggggggg"
+	dl.addVideo(link, "ggggggggggg", "Queued Video")
+	b, tg := newTestBot(t, dl)
+	b.config().AdminIDs = []int64{1}
+
+	// Another user keeps the only worker busy, so user 5's job waits
+	b.handleCallbackQuery(callback("v:best:" + b.cacheURL(busy)))
+	<-started
+	queued := callback("v:best:" + b.cacheURL(link))
+	queued.From = &tgbotapi.User{ID: 5}
+	b.handleCallbackQuery(queued)
+
+	b.handleUpdate(textUpdate(1, "/ban 5"))
+	tg.waitForText(t, "sendMessage", "🚫 User 5 is banned (1 jobs cancelled).")
+	tg.waitForText(t, "editMessageText", "✖ Download cancelled.")
+
+	keys, _ := b.store.Keys(bucketJobs)
+	var rec JobRecord
+	if len(keys) == 1 {
+		b.store.Get(bucketJobs, keys[0], &rec)
+	}
+	if rec.UserID != 5 || rec.Status != "cancelled" {
+		t.Errorf("job record = %+v, want user 5's job cancelled", rec)
+	}
+}
+
+func TestAdminCleanupAndBroadcast(t *testing.T) {
+	b, tg := newTestBot(t, newFakeDownloader())
+	b.config().AdminIDs = []int64{1}
+
+	old := filepath.Join(b.config().DownloadPath, "old.mp4")
+	fresh := filepath.Join(b.config().DownloadPath, "fresh.mp4")
+	os.WriteFile(old, []byte("old"), 0644)
+	os.WriteFile(fresh, []byte("fresh"), 0644)
+	twoHoursAgo := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(old, twoHoursAgo, twoHoursAgo)
+
+	b.handleUpdate(textUpdate(1, "/cleanup"))
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Error("old download was not removed")
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Error("recent download must be kept")
+	}
+
+	b.saveUserSettings(7, UserSettings{Format: "audio"})
+	b.handleUpdate(textUpdate(1, "/broadcast Maintenance at 5pm"))
+	tg.waitForText(t, "sendMessage", "📣 Broadcast sent to 1 chats")
+	if tg.waitForText(t, "sendMessage", "📣 Maintenance at 5pm").Params.Get("chat_id") != "7" {
+		t.Error("broadcast did not reach the known user")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+// audioFormat is an audio output the bot can produce.
+type audioFormat struct {
+	Name  string // value used in settings and callbacks
+	Label string
+	Codec string // yt-dlp --audio-format
+	Ext   string // extension of the extracted file
+	// Source prefers a stream that is already in Codec, so yt-dlp only
+	// remuxes it instead of transcoding.
+	Source   string
+	Lossless bool    // bitrate choices don't apply
+	Kbps     float64 // typical bitrate of "best", for size estimates
+	Voice    bool    // delivered as a Telegram voice note
+	Cover    bool    // can embed cover art
+}
+
+var audioFormats = []audioFormat{
+	{Name: "mp3", Label: "MP3", Codec: "mp3", Ext: "mp3", Kbps: 245, Cover: true},
+	{Name: "m4a", Label: "M4A (AAC)", Codec: "m4a", Ext: "m4a", Source: "bestaudio[ext=m4a]/bestaudio/best", Kbps: 130, Cover: true},
+	{Name: "opus", Label: "Opus", Codec: "opus", Ext: "opus", Source: "bestaudio[acodec=opus]/bestaudio/best", Kbps: 130, Cover: true},
+	{Name: "ogg", Label: "OGG Vorbis", Codec: "vorbis", Ext: "ogg", Kbps: 256, Cover: true},
+	{Name: "flac", Label: "FLAC", Codec: "flac", Ext: "flac", Lossless: true, Kbps: 1000, Cover: true},
+	{Name: "wav", Label: "WAV", Codec: "wav", Ext: "wav", Lossless: true, Kbps: 1411},
+	{Name: "voice", Label: "Voice note (Opus)", Codec: "opus", Ext: "opus", Source: "bestaudio[acodec=opus]/bestaudio/best", Kbps: 130, Voice: true},
+}
+
+// lookupAudioFormat finds an audio format by name; unknown names get MP3.
+func lookupAudioFormat(name string) (audioFormat, bool) {
+	for _, f := range audioFormats {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return audioFormats[0], false
+}
+
+// audioQuality is the --audio-quality for a format and menu quality.
+func (f audioFormat) audioQuality(quality string) string {
+	if f.Lossless {
+		return "0"
+	}
+	// yt-dlp's VBR "0" is ~500 kbps for Vorbis; cap it at a sane bitrate
+	if f.Codec == "vorbis" && (quality == "best" || quality == "") {
+		return "256K"
+	}
+	return getAudioBitrate(quality)
+}
@@ -0,0 +1,1039 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func newTestBot(t *testing.T, dl Downloader) (*Bot, *botAPIStub) {
+	t.Helper()
+	stub := newBotAPIStub(t)
+	api, err := tgbotapi.NewBotAPIWithAPIEndpoint("test-token", stub.endpoint())
+	if err != nil {
+		t.Fatalf("NewBotAPIWithAPIEndpoint: %v", err)
+	}
+	store, err := openFileStore(filepath.Join(t.TempDir(), "store.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	cfg := &Config{
+		DownloadPath:  t.TempDir(),
+		DataPath:      t.TempDir(),
+		Workers:       1,
+		QueueSize:     10,
+		URLCacheTTL:   time.Hour,
+		JobHistoryTTL: time.Hour,
+	}
+	b := newBot(api, dl, store, cfg)
+	b.media = &fakeMedia{}
+	b.jobs.Start(b.config().Workers, b.runJob)
+	t.Cleanup(b.jobs.Close)
+	return b, stub
+}
+
+// waitIdle blocks until every queued job has been handled.
+func waitIdle(t *testing.T, b *Bot) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if pending, active := b.jobs.Len(); pending == 0 && active == 0 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for jobs to finish")
+}
+
+func callback(data string) *tgbotapi.CallbackQuery {
+	return &tgbotapi.CallbackQuery{
+		ID:   "cb-1",
+		From: &tgbotapi.User{ID: 42},
+		Message: &tgbotapi.Message{
+			MessageID: 1,
+			Chat:      &tgbotapi.Chat{ID: 100},
+		},
+		Data: data,
+	}
+}
+
+func TestCallbackDownloadsVideo(t *testing.T) {
+	dl := newFakeDownloader()
+	link := "https://www.youtube.com/watch?v=aaaaaaaaaaa"
+	dl.addVideo(link, "aaaaaaaaaaa", "My Video")
+	b, tg := newTestBot(t, dl)
+
+	b.handleCallbackQuery(callback("v:720:" + b.cacheURL(link)))
+	waitIdle(t, b)
+
+	fetches := dl.fetched()
+	if len(fetches) != 1 {
+		t.Fatalf("got %d fetches, want 1", len(fetches))
+	}
+	if fetches[0].Format != "video" || fetches[0].Quality != "720" {
+		t.Errorf("fetch = %+v, want video/720", fetches[0])
+	}
+	if !strings.HasSuffix(fetches[0].Output, "My Video - aaaaaaaaaaa.mp4") {
+		t.Errorf("unexpected output path %q", fetches[0].Output)
+	}
+
+	videos := tg.find("sendVideo")
+	if len(videos) != 1 {
+		t.Fatalf("got %d sendVideo calls, want 1", len(videos))
+	}
+	if got := videos[0].Params.Get("caption"); got != "✅ My Video" {
+		t.Errorf("caption = %q", got)
+	}
+	if _, err := os.Stat(fetches[0].Output); !os.IsNotExist(err) {
+		t.Errorf("downloaded file was not cleaned up")
+	}
+	if len(tg.find("deleteMessage")) != 1 {
+		t.Errorf("processing message was not deleted")
+	}
+
+	var edits []string
+	for _, e := range tg.find("editMessageText") {
+		edits = append(edits, e.Params.Get("text"))
+	}
+	progress := strings.Join(edits, "\n")
+	for _, want := range []string{"Downloading... 50%", "Merging...", "Uploading..."} {
+		if !strings.Contains(progress, want) {
+			t.Errorf("status edits %q do not mention %q", edits, want)
+		}
+	}
+}
+
+func TestLocalBotAPIServer(t *testing.T) {
+	dl := newFakeDownloader()
+	b, tg := newTestBot(t, dl)
+
+	// A 100MB sparse file: over the public limit, fine for a local server
+	path := filepath.Join(b.config().DownloadPath, "big.mp4")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, 100*1024*1024); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.sendFile(100, path, delivery{Format: "video", Title: "Big"}); err == nil {
+		t.Fatal("public Bot API should reject a 100MB file")
+	}
+	if len(tg.find("sendVideo")) != 0 {
+		t.Fatal("oversized file was sent to the public Bot API")
+	}
+
+	b.config().BotAPIURL = "http://localhost:8081"
+	b.config().BotAPILocal = true
+	b.config().BotAPILocalFiles = true
+	if _, err := b.sendFile(100, path, delivery{Format: "video", Title: "Big"}); err != nil {
+		t.Fatalf("sendFile via local server: %v", err)
+	}
+	videos := tg.find("sendVideo")
+	if len(videos) != 1 {
+		t.Fatalf("got %d sendVideo calls, want 1", len(videos))
+	}
+	if got := videos[0].Params.Get("video"); got != "file://"+filepath.ToSlash(path) {
+		t.Errorf("video = %q, want a local file URI", got)
+	}
+	if len(videos[0].Files) != 0 {
+		t.Errorf("local server should not receive an upload, got files %v", videos[0].Files)
+	}
+}
+
+func TestFailedSplitRemovesDownload(t *testing.T) {
+	dl := newFakeDownloader()
+	link := "https://www.youtube.com/watch?v=bbbbbbbbbbb"
+	dl.addVideo(link, "bbbbbbbbbbb", "Long Video")
+	dl.setSize(link, 120*1024*1024)
+	b, tg := newTestBot(t, dl)
+	b.media.(*fakeMedia).err = fmt.Errorf("ffmpeg failed")
+
+	b.handleCallbackQuery(callback("v:best:" + b.cacheURL(link)))
+	waitIdle(t, b)
+
+	if len(tg.find("sendVideo")) != 0 {
+		t.Fatal("an oversized file was sent")
+	}
+	tg.waitForText(t, "sendMessage", "❌ File is too large")
+	if entries, _ := os.ReadDir(b.config().DownloadPath); len(entries) != 0 {
+		t.Errorf("download was not cleaned up: %v", entries)
+	}
+}
+
+func TestOversizedFileIsSplit(t *testing.T) {
+	dl := newFakeDownloader()
+	link := "https://www.youtube.com/watch?v=bbbbbbbbbbb"
+	dl.addVideo(link, "bbbbbbbbbbb", "Long Video")
+	dl.setSize(link, 120*1024*1024)
+	b, tg := newTestBot(t, dl)
+
+	b.handleCallbackQuery(callback("v:best:" + b.cacheURL(link)))
+	waitIdle(t, b)
+
+	videos := tg.find("sendVideo")
+	if len(videos) != 3 {
+		t.Fatalf("got %d sendVideo calls, want 3 parts", len(videos))
+	}
+	for i, v := range videos {
+		want := fmt.Sprintf("✅ Long Video (Part %d/3)", i+1)
+		if got := v.Params.Get("caption"); got != want {
+			t.Errorf("part %d caption = %q, want %q", i+1, got, want)
+		}
+	}
+	if entries, _ := os.ReadDir(b.config().DownloadPath); len(entries) != 0 {
+		t.Errorf("parts were not cleaned up: %v", entries)
+	}
+	if _, ok := b.cachedFileID(mediaKey("bbbbbbbbbbb", "video", "best")); ok {
+		t.Errorf("a split delivery must not be cached as a single file_id")
+	}
+}
+
+func TestFitToTelegramReencodes(t *testing.T) {
+	dl := newFakeDownloader()
+	link := "https://www.youtube.com/watch?v=ccccccccccc"
+	info := dl.addVideo(link, "ccccccccccc", "Huge Video")
+	info.Duration = 600
+	info.Formats = []Format{
+		{FormatID: "137", Ext: "mp4", VCodec: "avc1", ACodec: "none", Height: 1080, Filesize: 300 << 20},
+		{FormatID: "136", Ext: "mp4", VCodec: "avc1", ACodec: "none", Height: 720, Filesize: 150 << 20},
+		{FormatID: "140", Ext: "m4a", VCodec: "none", ACodec: "mp4a", Filesize: 10 << 20},
+	}
+	dl.setSize(link, 160<<20)
+	b, tg := newTestBot(t, dl)
+
+	b.handleCallbackQuery(callback("v:fit:" + b.cacheURL(link)))
+	waitIdle(t, b)
+
+	fetches := dl.fetched()
+	if len(fetches) != 1 || fetches[0].Quality != "720" {
+		t.Fatalf("fetches = %+v, want the smallest (720p) format", fetches)
+	}
+	if got := len(b.media.(*fakeMedia).reencodes); got != 1 {
+		t.Errorf("got %d re-encodes, want 1", got)
+	}
+	videos := tg.find("sendVideo")
+	if len(videos) != 1 {
+		t.Fatalf("got %d sendVideo calls, want 1", len(videos))
+	}
+	if got := videos[0].Params.Get("caption"); !strings.Contains(got, "720p, re-encoded to fit under 50.0 MB") {
+		t.Errorf("caption = %q, want an explanation of the delivered quality", got)
+	}
+	status := tg.waitForText(t, "editMessageText", "📦 Fit to Telegram")
+	if !strings.Contains(status.Params.Get("text"), "720p") {
+		t.Errorf("status = %q", status.Params.Get("text"))
+	}
+}
+
+func TestFitToTelegramReencodesOverEstimate(t *testing.T) {
+	dl := newFakeDownloader()
+	link := "https://www.youtube.com/watch?v=ccccccccccc"
+	info := dl.addVideo(link, "ccccccccccc", "Huge Video")
+	info.Duration = 600
+	info.Formats = []Format{
+		{FormatID: "137", Ext: "mp4", VCodec: "avc1", ACodec: "none", Height: 1080, Filesize: 300 << 20},
+		{FormatID: "136", Ext: "mp4", VCodec: "avc1", ACodec: "none", Height: 720, Filesize: 40 << 20},
+		{FormatID: "140", Ext: "m4a", VCodec: "none", ACodec: "mp4a", Filesize: 5 << 20},
+	}
+	// The 720p estimate fits, the actual download does not
+	dl.setSize(link, 60<<20)
+	b, tg := newTestBot(t, dl)
+
+	b.handleCallbackQuery(callback("v:fit:" + b.cacheURL(link)))
+	waitIdle(t, b)
+
+	if got := len(b.media.(*fakeMedia).reencodes); got != 1 {
+		t.Fatalf("got %d re-encodes, want 1", got)
+	}
+	videos := tg.find("sendVideo")
+	if len(videos) != 1 {
+		t.Fatalf("got %d sendVideo calls, want 1", len(videos))
+	}
+	if got := videos[0].Params.Get("caption"); !strings.Contains(got, "720p, re-encoded to fit under 50.0 MB") {
+		t.Errorf("caption = %q, want it to mention the re-encode", got)
+	}
+}
+
+func TestDailyQuota(t *testing.T) {
+	dl := newFakeDownloader()
+	link := "https://www.youtube.com/watch?v=eeeeeeeeeee"
+	dl.addVideo(link, "eeeeeeeeeee", "Quota Video")
+	b, tg := newTestBot(t, dl)
+	b.config().DailyQuotaBytes = 5
+
+	b.handleCallbackQuery(callback("v:720:" + b.cacheURL(link)))
+	waitIdle(t, b)
+	if got := b.dailyUsage(42).Bytes; got != int64(len("fake media")) {
+		t.Errorf("usage = %d bytes", got)
+	}
+
+	b.handleCallbackQuery(callback("v:480:" + b.cacheURL(link)))
+	waitIdle(t, b)
+	if got := len(dl.fetched()); got != 1 {
+		t.Errorf("got %d fetches, want the second request refused", got)
+	}
+	answers := tg.find("answerCallbackQuery")
+	last := answers[len(answers)-1]
+	if !strings.Contains(last.Params.Get("text"), "quota") || last.Params.Get("show_alert") != "true" {
+		t.Errorf("refusal = %v, want a quota alert", last.Params)
+	}
+}
+
+func TestMaxJobsPerUser(t *testing.T) {
+	dl := newFakeDownloader()
+	link := "https://www.youtube.com/watch?v=fffffffffff"
+	dl.addVideo(link, "fffffffffff", "Slow Video")
+	started := dl.blockFetch(link)
+	b, tg := newTestBot(t, dl)
+	b.config().MaxJobsPerUser = 1
+
+	b.handleCallbackQuery(callback("v:720:" + b.cacheURL(link)))
+	<-started
+	b.handleCallbackQuery(callback("v:480:" + b.cacheURL(link)))
+
+	answers := tg.find("answerCallbackQuery")
+	if last := answers[len(answers)-1].Params.Get("text"); !strings.Contains(last, "at most 1 downloads") {
+		t.Errorf("second request answered with %q", last)
+	}
+	if pending, active := b.jobs.Len(); pending+active != 1 {
+		t.Errorf("got %d jobs, want 1", pending+active)
+	}
+	b.jobs.Cancel(1)
+	waitIdle(t, b)
+}
+
+func TestCallbackDownloadsAudio(t *testing.T) {
+	dl := newFakeDownloader()
+	link := "https://youtu.be/bbbbbbbbbbb"
+	dl.addVideo(link, "bbbbbbbbbbb", "My Song")
+	b, tg := newTestBot(t, dl)
+
+	b.handleCallbackQuery(callback("a:320:" + b.cacheURL(link)))
+	waitIdle(t, b)
+
+	fetches := dl.fetched()
+	if len(fetches) != 1 || fetches[0].Format != "audio" || fetches[0].Quality != "320" {
+		t.Fatalf("fetches = %+v, want one audio/320 fetch", fetches)
+	}
+	if len(tg.find("sendAudio")) != 1 {
+		t.Errorf("expected one sendAudio call")
+	}
+}
+
+func TestAudioMetadataAndCover(t *testing.T) {
+	dl := newFakeDownloader()
+	link := "https://youtu.be/bbbbbbbbbbb"
+	info := dl.addVideo(link, "bbbbbbbbbbb", "Artist - Song (Official Video)")
+	info.Duration = 185
+	b, tg := newTestBot(t, dl)
+	media := b.media.(*fakeMedia)
+
+	b.handleCallbackQuery(callback("a:mp3:best:" + b.cacheURL(link)))
+	waitIdle(t, b)
+
+	fetches := dl.fetched()
+	if len(fetches) != 1 || !fetches[0].Thumbnail {
+		t.Fatalf("fetches = %+v, want one audio fetch with thumbnail", fetches)
+	}
+	audios := tg.find("sendAudio")
+	if len(audios) != 1 {
+		t.Fatalf("got %d sendAudio calls, want 1", len(audios))
+	}
+	p := audios[0].Params
+	if p.Get("performer") != "Test Channel" || p.Get("title") != "Artist - Song (Official Video)" || p.Get("duration") != "185" {
+		t.Errorf("audio metadata = performer %q, title %q, duration %q", p.Get("performer"), p.Get("title"), p.Get("duration"))
+	}
+	if files := strings.Join(audios[0].Files, " "); !strings.Contains(files, "thumb") {
+		t.Errorf("uploaded files = %q, want a thumbnail", files)
+	}
+	if len(media.thumbs) != 1 || media.thumbs[0] != thumbnailPath(fetches[0].Output) {
+		t.Errorf("thumbnails = %v", media.thumbs)
+	}
+	// The thumbnail and its scaled copy are cleaned up with the download
+	entries, _ := os.ReadDir(b.config().DownloadPath)
+	if len(entries) != 0 {
+		t.Errorf("left behind %d files", len(entries))
+	}
+}
+
+func TestCallbackDownloadsClip(t *testing.T) {
+	dl := newFakeDownloader()
+	link := "https://youtu.be/bbbbbbbbbbb"
+	info := dl.addVideo(link, "bbbbbbbbbbb", "Long Talk")
+	info.Duration = 3600
+	b, tg := newTestBot(t, dl)
+
+	b.handleCallbackQuery(callback("v:720:" + b.cacheURL(link+" 1:20-2:05")))
+	waitIdle(t, b)
+
+	fetches := dl.fetched()
+	if len(fetches) != 1 || fetches[0].URL != link || fetches[0].Clip == nil || fetches[0].Clip.section() != "*80-125" {
+		t.Fatalf("fetches = %+v, want one clip of 80-125", fetches)
+	}
+	videos := tg.find("sendVideo")
+	if len(videos) != 1 {
+		t.Fatalf("got %d sendVideo calls, want 1", len(videos))
+	}
+	if got := videos[0].Params.Get("caption"); got != "✅ Long Talk\n✂️ 1:20-2:05" {
+		t.Errorf("caption = %q", got)
+	}
+	if got := videos[0].Params.Get("duration"); got != "45" {
+		t.Errorf("duration = %q, want the clip's length", got)
+	}
+
+	// The whole video is not served from the clip's file_id
+	b.handleCallbackQuery(callback("v:720:" + b.cacheURL(link)))
+	waitIdle(t, b)
+	if fetches := dl.fetched(); len(fetches) != 2 || fetches[1].Clip != nil {
+		t.Errorf("fetches = %+v, want a second, full download", fetches)
+	}
+}
+
+func TestCallbackSplitsChapters(t *testing.T) {
+	dl := newFakeDownloader()
+	link := "https://youtu.be/ccccccccccc"
+	info := dl.addVideo(link, "ccccccccccc", "Live Album")
+	info.Duration = 300
+	info.Chapters = []Chapter{
+		{Title: "Opening", StartTime: 0, EndTime: 60},
+		{Title: "", StartTime: 60, EndTime: 200},
+		{Title: "Encore", StartTime: 200, EndTime: 300},
+	}
+	b, tg := newTestBot(t, dl)
+	media := b.media.(*fakeMedia)
+
+	b.handleCallbackQuery(callback("ch:a:" + b.cacheURL(link)))
+	waitIdle(t, b)
+
+	fetches := dl.fetched()
+	if len(fetches) != 1 || fetches[0].Format != "audio" || fetches[0].Album != "Live Album" {
+		t.Fatalf("fetches = %+v, want one audio fetch tagged with the album", fetches)
+	}
+	if len(media.chapters) != 1 || len(media.chapters[0]) != 3 {
+		t.Fatalf("chapter splits = %v", media.chapters)
+	}
+
+	var index string
+	for _, m := range tg.find("sendMessage") {
+		if strings.Contains(m.Params.Get("text"), "chapters:") {
+			index = m.Params.Get("text")
+		}
+	}
+	for _, want := range []string{"3 chapters", "1. 0:00 Opening", "2. 1:00 Chapter 2", "3. 3:20 Encore"} {
+		if !strings.Contains(index, want) {
+			t.Errorf("index %q lacks %q", index, want)
+		}
+	}
+
+	audios := tg.find("sendAudio")
+	if len(audios) != 3 {
+		t.Fatalf("got %d sendAudio calls, want 3", len(audios))
+	}
+	for i, want := range []string{"01. Opening", "02. Chapter 2", "03. Encore"} {
+		p := audios[i].Params
+		if p.Get("title") != want || p.Get("caption") != "✅ Live Album\n"+want {
+			t.Errorf("chapter %d: title %q, caption %q", i+1, p.Get("title"), p.Get("caption"))
+		}
+	}
+	if got := audios[1].Params.Get("duration"); got != "140" {
+		t.Errorf("chapter 2 duration = %q, want 140", got)
+	}
+	entries, _ := os.ReadDir(b.config().DownloadPath)
+	if len(entries) != 0 {
+		t.Errorf("left behind %d files", len(entries))
+	}
+}
+
+func TestSubtitleMenus(t *testing.T) {
+	dl := newFakeDownloader()
+	link := "https://youtu.be/ccccccccccc"
+	info := dl.addVideo(link, "ccccccccccc", "My Talk")
+	info.Subtitles = map[string][]SubtitleTrack{"en": {{Ext: "vtt", Name: "English"}}}
+	info.AutomaticCaptions = map[string][]SubtitleTrack{"de-orig": {{Ext: "vtt", Name: "German (Original)"}}, "fr": {{Ext: "vtt", Name: "French"}}}
+	b, tg := newTestBot(t, dl)
+	id := b.cacheURL(link)
+
+	b.handleCallbackQuery(callback("subs:" + id))
+	menu := tg.waitForText(t, "sendMessage", "📝 *Subtitles for My Talk*")
+	markup := menu.Params.Get("reply_markup")
+	for _, want := range []string{"subl:s:en:" + id, "subl:a:de-orig:" + id, "German (Original) (auto-generated)"} {
+		if !strings.Contains(markup, want) {
+			t.Errorf("language menu lacks %q: %s", want, markup)
+		}
+	}
+	if strings.Contains(markup, "subl:a:fr:") {
+		t.Errorf("translated captions are listed: %s", markup)
+	}
+
+	query := callback("subl:s:en:" + id)
+	query.Message.ReplyMarkup = &tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{
+		{tgbotapi.NewInlineKeyboardButtonData("English", "subl:s:en:"+id)},
+	}}
+	b.handleCallbackQuery(query)
+	modes := tg.waitForText(t, "editMessageText", "📝 Subtitles: English")
+	for _, mode := range []string{"srt", "vtt", "soft", "burn"} {
+		if want := "sub:" + mode + ":s:en:" + id; !strings.Contains(modes.Params.Get("reply_markup"), want) {
+			t.Errorf("delivery options lack %q", want)
+		}
+	}
+}
+
+func TestSubtitlesAsDocument(t *testing.T) {
+	dl := newFakeDownloader()
+	link := "https://youtu.be/ccccccccccc"
+	info := dl.addVideo(link, "ccccccccccc", "My Talk")
+	info.Subtitles = map[string][]SubtitleTrack{"en": {{Ext: "vtt", Name: "English"}}}
+	b, tg := newTestBot(t, dl)
+
+	b.handleCallbackQuery(callback("sub:srt:s:en:" + b.cacheURL(link)))
+	waitIdle(t, b)
+
+	fetches := dl.fetched()
+	if len(fetches) != 1 || fetches[0].Format != "subtitles" || fetches[0].Subtitles == nil || fetches[0].Subtitles.Lang != "en" {
+		t.Fatalf("fetches = %+v, want one English subtitle fetch", fetches)
+	}
+	docs := tg.find("sendDocument")
+	if len(docs) != 1 {
+		t.Fatalf("got %d sendDocument calls, want 1", len(docs))
+	}
+	if got := docs[0].Params.Get("caption"); got != "✅ My Talk\n📝 English" {
+		t.Errorf("caption = %q", got)
+	}
+	if !strings.HasSuffix(fetches[0].Output, "My Talk - ccccccccccc.srt") || len(docs[0].Files) != 1 {
+		t.Errorf("output = %q, uploaded files = %q", fetches[0].Output, docs[0].Files)
+	}
+	if len(tg.find("sendVideo")) != 0 {
+		t.Errorf("a video was sent for a subtitle request")
+	}
+	entries, _ := os.ReadDir(b.config().DownloadPath)
+	if len(entries) != 0 {
+		t.Errorf("left behind %d files", len(entries))
+	}
+}
+
+func TestSoftSubtitlesWithCompatDefault(t *testing.T) {
+	dl := newFakeDownloader()
+	link := "https://youtu.be/ccccccccccc"
+	info := dl.addVideo(link, "ccccccccccc", "My Talk")
+	info.Subtitles = map[string][]SubtitleTrack{"en": {{Ext: "vtt", Name: "English"}}}
+	b, tg := newTestBot(t, dl)
+	b.saveUserSettings(42, UserSettings{VideoFormat: "compat"})
+	media := b.media.(*fakeMedia)
+
+	b.handleCallbackQuery(callback("sub:soft:s:en:" + b.cacheURL(link)))
+	waitIdle(t, b)
+
+	fetches := dl.fetched()
+	if len(fetches) != 1 || fetches[0].Container != "mp4" || fetches[0].Subtitles == nil {
+		t.Fatalf("fetches = %+v, want one MP4 fetch with subtitles", fetches)
+	}
+	if len(media.converted) != 0 {
+		t.Errorf("converted %v; the conversion drops the subtitle track", media.converted)
+	}
+	if len(tg.find("sendVideo")) != 1 {
+		t.Fatal("video was not sent")
+	}
+	if _, ok := b.cachedFileID(mediaKey("ccccccccccc", "video", "best-subs-soft-sen")); !ok {
+		t.Error("video with subtitles was not cached as a plain MP4")
+	}
+}
+
+func TestSubtitlesBurnedIn(t *testing.T) {
+	dl := newFakeDownloader()
+	link := "https://youtu.be/ccccccccccc"
+	info := dl.addVideo(link, "ccccccccccc", "My Talk")
+	info.AutomaticCaptions = map[string][]SubtitleTrack{"en-orig": {{Ext: "vtt", Name: "English (Original)"}}}
+	b, tg := newTestBot(t, dl)
+	b.saveUserSettings(42, UserSettings{VideoFormat: "webm"})
+	media := b.media.(*fakeMedia)
+
+	b.handleCallbackQuery(callback("sub:burn:a:en-orig:" + b.cacheURL(link)))
+	waitIdle(t, b)
+
+	fetches := dl.fetched()
+	if len(fetches) != 1 || fetches[0].Format != "video" || fetches[0].Container != "mp4" || fetches[0].Subtitles == nil || !fetches[0].Subtitles.Auto {
+		t.Fatalf("fetches = %+v, want one MP4 fetch with captions", fetches)
+	}
+	if len(media.burns) != 1 || media.burns[0] != subtitlePath(fetches[0].Output, "en-orig", "srt") {
+		t.Errorf("burned = %v", media.burns)
+	}
+	videos := tg.find("sendVideo")
+	if len(videos) != 1 {
+		t.Fatalf("got %d sendVideo calls, want 1", len(videos))
+	}
+	if got := videos[0].Params.Get("caption"); got != "✅ My Talk\n📝 English (Original) (auto-generated)" {
+		t.Errorf("caption = %q", got)
+	}
+	entries, _ := os.ReadDir(b.config().DownloadPath)
+	if len(entries) != 0 {
+		t.Errorf("left behind %d files", len(entries))
+	}
+}
+
+func TestTranscriptCommand(t *testing.T) {
+	dl := newFakeDownloader()
+	link := "https://youtu.be/ccccccccccc"
+	info := dl.addVideo(link, "ccccccccccc", "My Talk")
+	info.Subtitles = map[string][]SubtitleTrack{"en": {{Ext: "vtt", Name: "English"}}}
+	info.AutomaticCaptions = map[string][]SubtitleTrack{"en-orig": {{Ext: "vtt"}}}
+	dl.setSubtitles(link, rollingVTT)
+	b, tg := newTestBot(t, dl)
+
+	b.handleUpdate(textUpdate(7, "/transcript "+link+" timestamps"))
+	waitIdle(t, b)
+
+	fetches := dl.fetched()
+	if len(fetches) != 1 || fetches[0].Format != "subtitles" || fetches[0].Subtitles.Lang != "en" || fetches[0].Subtitles.Auto || fetches[0].Subtitles.Mode != "vtt" {
+		t.Fatalf("fetches = %+v, want the uploaded English subtitles as VTT", fetches)
+	}
+	msg := tg.waitForText(t, "sendMessage", "🧾 My Talk")
+	want := "🧾 My Talk\n📝 English\n\n[0:00] hello everyone and welcome to the talk.\n\n[0:09] Tom & Jerry are next"
+	if got := msg.Params.Get("text"); got != want {
+		t.Errorf("transcript message = %q", got)
+	}
+	entries, _ := os.ReadDir(b.config().DownloadPath)
+	if len(entries) != 0 {
+		t.Errorf("left behind %d files", len(entries))
+	}
+}
+
+func TestLongTranscriptAsDocument(t *testing.T) {
+	dl := newFakeDownloader()
+	link := "https://youtu.be/ccccccccccc"
+	info := dl.addVideo(link, "ccccccccccc", "My Talk")
+	info.AutomaticCaptions = map[string][]SubtitleTrack{"en-orig": {{Ext: "vtt"}}}
+	var vtt strings.Builder
+	vtt.WriteString("WEBVTT\n")
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&vtt, "\n00:%02d:%02d.000 --> 00:%02d:%02d.500\nline number %d of the talk\n", i/60, i%60, i/60, i%60, i)
+	}
+	dl.setSubtitles(link, vtt.String())
+	b, tg := newTestBot(t, dl)
+
+	b.handleCallbackQuery(callback("tr:p:" + b.cacheURL(link)))
+	waitIdle(t, b)
+
+	if fetches := dl.fetched(); len(fetches) != 1 || !fetches[0].Subtitles.Auto {
+		t.Fatalf("fetches = %+v, want the auto-captions", fetches)
+	}
+	docs := tg.find("sendDocument")
+	if len(docs) != 1 {
+		t.Fatalf("got %d sendDocument calls, want 1", len(docs))
+	}
+	if got := docs[0].Params.Get("caption"); got != "🧾 My Talk\n📝 en-orig (auto-generated)" {
+		t.Errorf("caption = %q", got)
+	}
+	entries, _ := os.ReadDir(b.config().DownloadPath)
+	if len(entries) != 0 {
+		t.Errorf("left behind %d files", len(entries))
+	}
+}
+
+func TestCallbackSendsThumbnail(t *testing.T) {
+	dl := newFakeDownloader()
+	link := "https://youtu.be/ccccccccccc"
+	info := dl.addVideo(link, "ccccccccccc", "My Talk")
+	info.Thumbnails = []Thumbnail{{ID: "0", Width: 120, Height: 90}, {ID: "1", Width: 1920, Height: 1080}, {ID: "2", Width: 480, Height: 360}}
+	b, tg := newTestBot(t, dl)
+
+	b.handleCallbackQuery(callback("thumb:" + b.cacheURL(link)))
+	waitIdle(t, b)
+
+	fetches := dl.fetched()
+	if len(fetches) != 1 || fetches[0].Format != "thumbnail" || !fetches[0].Thumbnail {
+		t.Fatalf("fetches = %+v, want one thumbnail fetch", fetches)
+	}
+	photos := tg.find("sendPhoto")
+	if len(photos) != 1 || photos[0].Params.Get("caption") != "🖼 My Talk\n1920×1080" {
+		t.Fatalf("photos = %+v", photos)
+	}
+	if docs := tg.find("sendDocument"); len(docs) != 1 || len(docs[0].Files) != 1 {
+		t.Errorf("documents = %+v, want the uncompressed file", docs)
+	}
+	if len(tg.find("sendVideo")) != 0 {
+		t.Errorf("a video was sent for a thumbnail request")
+	}
+	entries, _ := os.ReadDir(b.config().DownloadPath)
+	if len(entries) != 0 {
+		t.Errorf("left behind %d files", len(entries))
+	}
+}
+
+func TestClipRangeErrors(t *testing.T) {
+	dl := newFakeDownloader()
+	b, tg := newTestBot(t, dl)
+
+	b.handleUpdate(textUpdate(7, "https://youtu.be/bbbbbbbbbbb 2:05-1:20"))
+	msgs := tg.find("sendMessage")
+	if len(msgs) != 1 || !strings.Contains(msgs[0].Params.Get("text"), "time range") {
+		t.Fatalf("messages = %+v, want a time range error", msgs)
+	}
+	if len(dl.fetched()) != 0 {
+		t.Errorf("an invalid clip was downloaded")
+	}
+}
+
+func TestMusicMetadataPrefersTrackAndArtist(t *testing.T) {
+	meta := newMediaMeta(&VideoInfo{Title: "Artist - Song (Official Video)", Uploader: "ArtistVEVO", Artist: "Artist", Track: "Song"})
+	if meta.Performer != "Artist" || meta.Title != "Song" {
+		t.Errorf("meta = %+v", meta)
+	}
+}
+
+func TestCallbackAudioFormats(t *testing.T) {
+	dl := newFakeDownloader()
+	link := "https://youtu.be/bbbbbbbbbbb"
+	dl.addVideo(link, "bbbbbbbbbbb", "My Song")
+	b, tg := newTestBot(t, dl)
+	id := b.cacheURL(link)
+
+	b.handleCallbackQuery(callback("a:flac:best:" + id))
+	waitIdle(t, b)
+	b.handleCallbackQuery(callback("a:voice:best:" + id))
+	waitIdle(t, b)
+
+	fetches := dl.fetched()
+	if len(fetches) != 2 {
+		t.Fatalf("got %d fetches, want 2", len(fetches))
+	}
+	if f := fetches[0]; f.Audio != "flac" || !strings.HasSuffix(f.Output, ".flac") {
+		t.Errorf("flac fetch = %+v", f)
+	}
+	if f := fetches[1]; f.Audio != "voice" || !strings.HasSuffix(f.Output, ".opus") {
+		t.Errorf("voice fetch = %+v", f)
+	}
+	if len(tg.find("sendAudio")) != 1 || len(tg.find("sendVoice")) != 1 {
+		t.Errorf("want the FLAC as audio and the Opus as a voice note")
+	}
+
+	// Unknown formats are ignored
+	b.handleCallbackQuery(callback("a:aiff:best:" + id))
+	waitIdle(t, b)
+	if len(dl.fetched()) != 2 {
+		t.Errorf("unknown audio format started a download")
+	}
+}
+
+func TestVideoMetadataOnUpload(t *testing.T) {
+	dl := newFakeDownloader()
+	link := "https://www.youtube.com/watch?v=aaaaaaaaaaa"
+	info := dl.addVideo(link, "aaaaaaaaaaa", "My Video")
+	info.Duration = 100
+	b, tg := newTestBot(t, dl)
+	media := b.media.(*fakeMedia)
+	media.probe = VideoProbe{Width: 1280, Height: 720, Duration: 99.6}
+
+	b.handleCallbackQuery(callback("v:720:" + b.cacheURL(link)))
+	waitIdle(t, b)
+
+	videos := tg.find("sendVideo")
+	if len(videos) != 1 {
+		t.Fatalf("got %d sendVideo calls, want 1", len(videos))
+	}
+	p := videos[0].Params
+	for key, want := range map[string]string{"width": "1280", "height": "720", "duration": "100", "supports_streaming": "true", "caption": "✅ My Video"} {
+		if got := p.Get(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	if files := strings.Join(videos[0].Files, " "); files != "video thumb" && files != "thumb video" {
+		t.Errorf("uploaded files = %q, want video and thumb", files)
+	}
+	// The downloaded thumbnail is used rather than a frame of the video
+	if len(media.thumbs) != 1 || !strings.HasSuffix(media.thumbs[0], ".jpg") {
+		t.Errorf("thumbnails = %v", media.thumbs)
+	}
+}
+
+func TestCallbackVideoContainers(t *testing.T) {
+	dl := newFakeDownloader()
+	link := "https://www.youtube.com/watch?v=aaaaaaaaaaa"
+	dl.addVideo(link, "aaaaaaaaaaa", "My Video")
+	b, tg := newTestBot(t, dl)
+	media := b.media.(*fakeMedia)
+	id := b.cacheURL(link)
+
+	b.handleCallbackQuery(callback("v:webm:720:" + id))
+	waitIdle(t, b)
+	b.handleCallbackQuery(callback("v:compat:best:" + id))
+	waitIdle(t, b)
+
+	fetches := dl.fetched()
+	if len(fetches) != 2 {
+		t.Fatalf("got %d fetches, want 2", len(fetches))
+	}
+	if f := fetches[0]; f.Container != "webm" || f.Quality != "720" || !strings.HasSuffix(f.Output, ".webm") {
+		t.Errorf("webm fetch = %+v", f)
+	}
+	if f := fetches[1]; f.Container != "compat" || !strings.HasSuffix(f.Output, ".mp4") {
+		t.Errorf("compat fetch = %+v", f)
+	}
+	// WebM can't play inline, so it goes out as a file
+	if len(tg.find("sendDocument")) != 1 || len(tg.find("sendVideo")) != 1 {
+		t.Errorf("want the WebM as a document and the compatible MP4 as a video")
+	}
+	if len(media.converted) != 1 || media.converted[0] != fetches[1].Output {
+		t.Errorf("converted = %v, want the compat download", media.converted)
+	}
+}
+
+func TestRepeatRequestReusesFileID(t *testing.T) {
+	dl := newFakeDownloader()
+	link := "https://youtu.be/eeeeeeeeeee"
+	dl.addVideo(link, "eeeeeeeeeee", "Popular Song")
+	b, tg := newTestBot(t, dl)
+	id := b.cacheURL(link)
+
+	b.handleCallbackQuery(callback("a:192:" + id))
+	waitIdle(t, b)
+	b.handleCallbackQuery(callback("a:192:" + id))
+	waitIdle(t, b)
+
+	if got := len(dl.fetched()); got != 1 {
+		t.Fatalf("got %d fetches, want the repeat request served from cache", got)
+	}
+	if dl.infoCalls != 1 {
+		t.Errorf("metadata fetched %d times, want 1", dl.infoCalls)
+	}
+	if dl.fetched()[0].Info == nil {
+		t.Errorf("download was not fed the fetched metadata")
+	}
+	audios := tg.find("sendAudio")
+	if len(audios) != 2 {
+		t.Fatalf("got %d sendAudio calls, want 2", len(audios))
+	}
+	if !strings.Contains(strings.Join(audios[0].Files, " "), "audio") {
+		t.Errorf("first delivery should upload the file")
+	}
+	if len(audios[1].Files) != 0 || !strings.HasPrefix(audios[1].Params.Get("audio"), "file-") {
+		t.Errorf("second delivery should reuse the file_id, got %+v", audios[1])
+	}
+
+	// A different quality is a different file
+	b.handleCallbackQuery(callback("a:128:" + id))
+	waitIdle(t, b)
+	if got := len(dl.fetched()); got != 2 {
+		t.Errorf("got %d fetches, want a new download for another quality", got)
+	}
+}
+
+func TestPlaylistAudioIsCachedWithItsTags(t *testing.T) {
+	dl := newFakeDownloader()
+	link := "https://www.youtube.com/watch?v=item0000001"
+	dl.addVideo(link, "item0000001", "Item 1")
+	playlist := "https://www.youtube.com/playlist?list=PLtags"
+	dl.addPlaylist(playlist, PlaylistEntry{Title: "Item 1", URL: link, Playlist: "Test Album"})
+	b, _ := newTestBot(t, dl)
+
+	b.handleCallbackQuery(callback("a:best:" + b.cacheURL(link)))
+	waitIdle(t, b)
+	b.handleCallbackQuery(callback("pa:1:best:" + b.cacheURL(playlist)))
+	waitIdle(t, b)
+	b.handleCallbackQuery(callback("pa:1:best:" + b.cacheURL(playlist)))
+	waitIdle(t, b)
+
+	fetches := dl.fetched()
+	if len(fetches) != 2 {
+		t.Fatalf("got %d fetches, want the untagged and the tagged file once each", len(fetches))
+	}
+	if f := fetches[1]; f.Album != "Test Album" || f.Track != 1 {
+		t.Errorf("playlist item tagged album %q track %d, want Test Album/1", f.Album, f.Track)
+	}
+}
+
+func TestCancelRunningDownload(t *testing.T) {
+	dl := newFakeDownloader()
+	link := "https://youtu.be/fffffffffff"
+	dl.addVideo(link, "fffffffffff", "Long Talk")
+	started := dl.blockFetch(link)
+	b, tg := newTestBot(t, dl)
+
+	b.handleCallbackQuery(callback("v:best:" + b.cacheURL(link)))
+	<-started
+
+	// Someone else in the group can't cancel it
+	other := callback("cancel:1")
+	other.From = &tgbotapi.User{ID: 7}
+	b.handleCallbackQuery(other)
+	if pending, active := b.jobs.Len(); pending+active != 1 {
+		t.Fatalf("job stopped after a cancel from another user")
+	}
+
+	b.handleCallbackQuery(callback("cancel:1"))
+	waitIdle(t, b)
+
+	if len(tg.find("sendVideo")) != 0 {
+		t.Errorf("cancelled download was delivered")
+	}
+	edits := tg.find("editMessageText")
+	if last := edits[len(edits)-1].Params.Get("text"); last != "✖ Download cancelled." {
+		t.Errorf("last status = %q", last)
+	}
+	if files, _ := os.ReadDir(b.downloadPath); len(files) != 0 {
+		t.Errorf("partial files left behind: %v", files)
+	}
+	keys, _ := b.store.Keys(bucketJobs)
+	var rec JobRecord
+	if len(keys) == 1 {
+		b.store.Get(bucketJobs, keys[0], &rec)
+	}
+	if rec.Status != "cancelled" {
+		t.Errorf("job record = %+v, want cancelled", rec)
+	}
+}
+
+func TestShutdownReportsDroppedJobs(t *testing.T) {
+	dl := newFakeDownloader()
+	busy := "https://youtu.be/fffffffffff"
+	dl.addVideo(busy, "fffffffffff", "Long Talk")
+	started := dl.blockFetch(busy)
+	link := "https://youtu.be/ggggggggggg"
+	dl.addVideo(link, "ggggggggggg", "Queued Video")
+	b, tg := newTestBot(t, dl)
+
+	b.handleCallbackQuery(callback("v:best:" + b.cacheURL(busy)))
+	<-started
+	b.handleCallbackQuery(callback("v:best:" + b.cacheURL(link)))
+	b.jobs.Close()
+
+	tg.waitForText(t, "editMessageText", "✖ Download cancelled because the bot is shutting down.")
+	keys, _ := b.store.Keys(bucketJobs)
+	if len(keys) != 2 {
+		t.Fatalf("got %d job records, want the running and the waiting job", len(keys))
+	}
+	for _, key := range keys {
+		var rec JobRecord
+		if b.store.Get(bucketJobs, key, &rec); rec.Status != "cancelled" {
+			t.Errorf("job record = %+v, want cancelled", rec)
+		}
+	}
+}
+
+func TestCancelPlaylistReportsProgress(t *testing.T) {
+	dl := newFakeDownloader()
+	playlist := "https://www.youtube.com/playlist?list=PLcancel"
+	var entries []PlaylistEntry
+	for i := 1; i <= 3; i++ {
+		link := fmt.Sprintf("https://www.youtube.com/watch?v=canc%07d", i)
+		dl.addVideo(link, fmt.Sprintf("canc%07d", i), fmt.Sprintf("Item %d", i))
+		entries = append(entries, PlaylistEntry{Title: fmt.Sprintf("Item %d", i), URL: link})
+	}
+	dl.addPlaylist(playlist, entries...)
+	started := dl.blockFetch(entries[1].URL)
+	b, tg := newTestBot(t, dl)
+
+	b.handleCallbackQuery(callback("p:3:best:" + b.cacheURL(playlist)))
+	<-started
+	b.handleCallbackQuery(callback("cancel:1"))
+	waitIdle(t, b)
+
+	if got := len(dl.fetched()); got != 2 {
+		t.Errorf("got %d fetches, want the third item skipped", got)
+	}
+	edits := tg.find("editMessageText")
+	if last := edits[len(edits)-1].Params.Get("text"); last != "✖ Playlist download cancelled. Delivered 1/3 items." {
+		t.Errorf("last status = %q", last)
+	}
+}
+
+func TestCallbackExpiredLink(t *testing.T) {
+	dl := newFakeDownloader()
+	b, tg := newTestBot(t, dl)
+
+	b.handleCallbackQuery(callback("v:best:000000000000"))
+	waitIdle(t, b)
+
+	if len(dl.fetched()) != 0 {
+		t.Errorf("expired link should not start a download")
+	}
+	answers := tg.find("answerCallbackQuery")
+	if len(answers) != 1 || !strings.Contains(answers[0].Params.Get("text"), "Link expired") {
+		t.Errorf("answers = %+v, want link expired notice", answers)
+	}
+}
+
+func TestCallbackDownloadError(t *testing.T) {
+	dl := newFakeDownloader()
+	link := "https://youtu.be/ccccccccccc"
+	dl.addVideo(link, "ccccccccccc", "Gone")
+	dl.failFetch(link, fmt.Errorf("Video is unavailable or has been removed."))
+	b, tg := newTestBot(t, dl)
+
+	b.handleCallbackQuery(callback("v:best:" + b.cacheURL(link)))
+	waitIdle(t, b)
+
+	if len(tg.find("sendVideo")) != 0 {
+		t.Errorf("no video should be sent on failure")
+	}
+	var found bool
+	for _, m := range tg.find("sendMessage") {
+		if strings.Contains(m.Params.Get("text"), "❌ Error: Video is unavailable") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("user was not told about the error")
+	}
+
+	keys, _ := b.store.Keys(bucketJobs)
+	var rec JobRecord
+	if len(keys) != 1 {
+		t.Fatalf("got %d job records, want 1", len(keys))
+	}
+	b.store.Get(bucketJobs, keys[0], &rec)
+	if rec.Status != "failed" || rec.URL != link || rec.UserID != 42 {
+		t.Errorf("job record = %+v", rec)
+	}
+}
+
+func TestCallbackPlaylist(t *testing.T) {
+	dl := newFakeDownloader()
+	playlist := "https://www.youtube.com/playlist?list=PLtest"
+	var entries []PlaylistEntry
+	for i := 1; i <= 4; i++ {
+		link := fmt.Sprintf("https://www.youtube.com/watch?v=item%07d", i)
+		dl.addVideo(link, fmt.Sprintf("item%07d", i), fmt.Sprintf("Item %d", i))
+		entries = append(entries, PlaylistEntry{Title: fmt.Sprintf("Item %d", i), URL: link, Playlist: "Test Album"})
+	}
+	dl.addPlaylist(playlist, entries...)
+	dl.failFetch(entries[1].URL, fmt.Errorf("Download failed"))
+	b, tg := newTestBot(t, dl)
+
+	b.handleCallbackQuery(callback("pa:3:best:" + b.cacheURL(playlist)))
+	waitIdle(t, b)
+
+	fetches := dl.fetched()
+	if got := len(fetches); got != 3 {
+		t.Fatalf("got %d fetches, want 3", got)
+	}
+	if f := fetches[2]; f.Album != "Test Album" || f.Track != 3 {
+		t.Errorf("third item tagged album %q track %d, want Test Album/3", f.Album, f.Track)
+	}
+	if got := len(tg.find("sendAudio")); got != 2 {
+		t.Errorf("got %d sendAudio calls, want 2", got)
+	}
+	msgs := tg.find("sendMessage")
+	last := msgs[len(msgs)-1].Params.Get("text")
+	if last != "✅ Downloaded 2/3 items from playlist!" {
+		t.Errorf("completion message = %q", last)
+	}
+}
+
+func TestCallbackOpenPlaylistItem(t *testing.T) {
+	dl := newFakeDownloader()
+	b, tg := newTestBot(t, dl)
+	id := b.cacheURL("https://www.youtube.com/watch?v=ddddddddddd")
+
+	b.handleCallbackQuery(callback("open:" + id))
+
+	menu := tg.waitForText(t, "editMessageText", "📥")
+	if markup := menu.Params.Get("reply_markup"); !strings.Contains(markup, "v:720:"+id) {
+		t.Errorf("quality menu is missing the 720p option: %s", markup)
+	}
+}
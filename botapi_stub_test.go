@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// botAPIStub is an in-process stand-in for the Telegram Bot API. It serves
+// queued updates through getUpdates and records every other call.
+type botAPIStub struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	calls   []apiCall
+	updates []tgbotapi.Update
+	nextID  int
+	changed chan struct{}
+}
+
+type apiCall struct {
+	Method string
+	Params url.Values
+	Files  []string
+}
+
+func newBotAPIStub(t *testing.T) *botAPIStub {
+	t.Helper()
+	s := &botAPIStub{changed: make(chan struct{}, 1)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// endpoint is the API endpoint format expected by tgbotapi.NewBotAPIWithAPIEndpoint.
+func (s *botAPIStub) endpoint() string {
+	return s.URL + "/bot%s/%s"
+}
+
+// pushUpdates queues updates for delivery through getUpdates.
+func (s *botAPIStub) pushUpdates(updates ...tgbotapi.Update) {
+	s.mu.Lock()
+	s.updates = append(s.updates, updates...)
+	s.mu.Unlock()
+	s.signal()
+}
+
+func (s *botAPIStub) signal() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// find returns all recorded calls of the given API method.
+func (s *botAPIStub) find(method string) []apiCall {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []apiCall
+	for _, call := range s.calls {
+		if call.Method == method {
+			out = append(out, call)
+		}
+	}
+	return out
+}
+
+// waitFor blocks until at least n calls of method have been recorded.
+func (s *botAPIStub) waitFor(t *testing.T, method string, n int) []apiCall {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if calls := s.find(method); len(calls) >= n {
+			return calls
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d %s calls", n, method)
+	return nil
+}
+
+// waitForText blocks until a call of method carries a text starting with prefix.
+func (s *botAPIStub) waitForText(t *testing.T, method, prefix string) apiCall {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, call := range s.find(method) {
+			if strings.HasPrefix(call.Params.Get("text"), prefix) {
+				return call
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s with text %q", method, prefix)
+	return apiCall{}
+}
+
+func (s *botAPIStub) serve(w http.ResponseWriter, r *http.Request) {
+	// Paths look like /bot<token>/<method>
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "bot") {
+		http.NotFound(w, r)
+		return
+	}
+	call := apiCall{Method: parts[1]}
+	if err := r.ParseMultipartForm(32 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	call.Params = r.Form
+	if r.MultipartForm != nil {
+		for field := range r.MultipartForm.File {
+			call.Files = append(call.Files, field)
+		}
+	}
+
+	if call.Method == "getUpdates" {
+		offset, _ := strconv.Atoi(call.Params.Get("offset"))
+		writeAPIResult(w, s.pendingUpdates(offset))
+		return
+	}
+
+	s.mu.Lock()
+	s.calls = append(s.calls, call)
+	s.nextID++
+	id := s.nextID
+	s.mu.Unlock()
+
+	switch call.Method {
+	case "getMe":
+		writeAPIResult(w, tgbotapi.User{ID: 1, IsBot: true, FirstName: "Test", UserName: "test_bot"})
+	case "sendMessage", "sendPhoto", "sendVideo", "sendAudio", "sendDocument", "sendVoice", "editMessageText":
+		writeAPIResult(w, stubMessage(call, id))
+	case "answerCallbackQuery", "deleteMessage", "setMyCommands":
+		writeAPIResult(w, true)
+	default:
+		writeAPIError(w, http.StatusNotFound, "Not Found: method not found")
+	}
+}
+
+// pendingUpdates returns updates with an ID >= offset, waiting briefly when
+// there are none so the polling loop does not spin.
+func (s *botAPIStub) pendingUpdates(offset int) []tgbotapi.Update {
+	timeout := time.After(50 * time.Millisecond)
+	for {
+		s.mu.Lock()
+		var out []tgbotapi.Update
+		for _, u := range s.updates {
+			if u.UpdateID >= offset {
+				out = append(out, u)
+			}
+		}
+		s.mu.Unlock()
+		if len(out) > 0 {
+			return out
+		}
+		select {
+		case <-s.changed:
+		case <-timeout:
+			return []tgbotapi.Update{}
+		}
+	}
+}
+
+func stubMessage(call apiCall, id int) map[string]interface{} {
+	chatID, _ := strconv.ParseInt(call.Params.Get("chat_id"), 10, 64)
+	msg := map[string]interface{}{
+		"message_id": id,
+		"date":       time.Now().Unix(),
+		"chat":       map[string]interface{}{"id": chatID, "type": "private"},
+	}
+	if text := call.Params.Get("text"); text != "" {
+		msg["text"] = text
+	}
+	fileID := fmt.Sprintf("file-%d", id)
+	switch call.Method {
+	case "sendVideo":
+		msg["video"] = map[string]interface{}{"file_id": fileID, "file_unique_id": fileID}
+	case "sendAudio":
+		msg["audio"] = map[string]interface{}{"file_id": fileID, "file_unique_id": fileID}
+	case "sendDocument":
+		msg["document"] = map[string]interface{}{"file_id": fileID, "file_unique_id": fileID}
+	case "sendVoice":
+		msg["voice"] = map[string]interface{}{"file_id": fileID, "file_unique_id": fileID}
+	}
+	return msg
+}
+
+func writeAPIResult(w http.ResponseWriter, result interface{}) {
+	raw, _ := json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tgbotapi.APIResponse{Ok: true, Result: raw})
+}
+
+func writeAPIError(w http.ResponseWriter, code int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tgbotapi.APIResponse{Ok: false, ErrorCode: code, Description: description})
+}
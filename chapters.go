@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// chapterIndex lists a video's chapters with their start times, sent ahead
+// of the chapter files.
+func chapterIndex(title string, chapters []Chapter) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "📑 *%s*\n%d chapters:\n", tgbotapi.EscapeText(tgbotapi.ModeMarkdown, title), len(chapters))
+	for i, ch := range chapters {
+		fmt.Fprintf(&sb, "\n%d. %s %s", i+1, formatDuration(ch.StartTime), tgbotapi.EscapeText(tgbotapi.ModeMarkdown, chapterTitle(ch, i)))
+	}
+	return sb.String()
+}
+
+// chapterTitle is a chapter's name, or its number when it has none.
+func chapterTitle(ch Chapter, i int) string {
+	if t := strings.TrimSpace(ch.Title); t != "" {
+		return t
+	}
+	return fmt.Sprintf("Chapter %d", i+1)
+}
+
+// deliverChapters downloads a video or audio once, cuts it at its chapters
+// and sends one numbered file per chapter after an index message. Chapter
+// files are not cached by file_id.
+func (b *Bot) deliverChapters(ctx context.Context, chatID, userID int64, req mediaRequest, progress func(Progress)) error {
+	info, err := b.videoInfo(ctx, req.URL)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	if len(info.Chapters) == 0 {
+		return fmt.Errorf("this video has no chapters")
+	}
+	if !b.media.Available() {
+		return fmt.Errorf("ffmpeg is not installed")
+	}
+	title := info.Title
+	if title == "" {
+		title = info.ID
+	}
+
+	settings := b.userSettings(userID).withDefaults()
+	switch {
+	case req.OutputFormat == "":
+	case req.Format == "video":
+		settings.VideoFormat = req.OutputFormat
+	default:
+		settings.AudioFormat = req.OutputFormat
+	}
+	if req.Format == "audio" && req.Album == "" {
+		// The chapters are the tracks of an album named after the video
+		req.Album = title
+	}
+
+	filePath, err := b.downloadMedia(ctx, req, info, req.Quality, settings.outputFormat(req.Format), progress)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(filePath)
+	thumb := b.prepareThumbnail(ctx, filePath, req.Format)
+	if thumb != "" {
+		defer os.Remove(thumb)
+	}
+	if stat, err := os.Stat(filePath); err == nil {
+		b.addUsage(userID, stat.Size(), info.Duration)
+	}
+
+	if container, _ := lookupVideoContainer(settings.VideoFormat); req.Format == "video" && container.Compatible {
+		filePath, err = b.makeCompatible(ctx, filePath, progress)
+		defer os.Remove(filePath)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			log.Printf("Failed to convert %s: %v", filePath, err)
+		}
+	}
+
+	if progress != nil {
+		progress(Progress{Stage: "splitting chapters", Percent: -1})
+	}
+	files, err := b.media.SplitChapters(ctx, filePath, info.Chapters)
+	defer removeFiles(files)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("could not split the chapters: %v", err)
+	}
+
+	index := tgbotapi.NewMessage(chatID, chapterIndex(title, info.Chapters))
+	index.ParseMode = "Markdown"
+	b.api.Send(index)
+
+	base := newMediaMeta(info)
+	for i, file := range files {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if progress != nil {
+			progress(Progress{Stage: fmt.Sprintf("uploading %d/%d", i+1, len(files)), Percent: -1})
+		}
+		ch := info.Chapters[i]
+		name := fmt.Sprintf("%02d. %s", i+1, chapterTitle(ch, i))
+		d := delivery{Format: req.Format, Title: title + "\n" + name, Settings: settings, Meta: base}
+		d.Meta.Title = name
+		d.Meta.Duration = int(ch.EndTime - ch.StartTime + 0.5)
+		d.Meta.Thumb = thumb
+		if req.Format == "video" {
+			b.probeVideo(ctx, file, &d.Meta)
+		}
+
+		log.Printf("Sending chapter %d/%d: %s", i+1, len(files), file)
+		if stat, err := os.Stat(file); err == nil && stat.Size() > b.config().UploadLimit() {
+			if err := b.deliverParts(ctx, chatID, file, d, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := b.sendFile(chatID, file, d); err != nil {
+			return fmt.Errorf("%w: %v", errSendFailed, err)
+		}
+	}
+	return nil
+}
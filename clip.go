@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// clipRange is a section of a video to download, in seconds.
+type clipRange struct {
+	Start, End float64
+}
+
+var (
+	// A position such as "80", "1:20" or "1:02:03"
+	clockRegex = regexp.MustCompile(`^\d+(:[0-5]?\d){0,2}$`)
+	// YouTube's t= parameter: "80", "80s", "1m20s" or "1h2m3s"
+	youtubeTimeRegex = regexp.MustCompile(`^(?:(\d+)h)?(?:(\d+)m)?(?:(\d+)s?)?$`)
+)
+
+// splitClip separates a link from a time range written after it, e.g.
+// "https://youtu.be/ID 1:20-2:05". The start may instead come from the
+// link's t= parameter: "https://youtu.be/ID?t=80 2:05". The clip is nil
+// when the message is just a link.
+func splitClip(text string) (string, *clipRange, error) {
+	fields := strings.Fields(text)
+	if len(fields) < 2 {
+		return strings.TrimSpace(text), nil, nil
+	}
+	link := fields[0]
+	spec := strings.Join(fields[1:], "")
+
+	startText, endText, ranged := strings.Cut(spec, "-")
+	if !ranged {
+		startText, endText = "", spec
+	}
+	var c clipRange
+	var err error
+	if startText == "" {
+		t, ok := linkStartTime(link)
+		if !ok {
+			return "", nil, fmt.Errorf("a clip needs a start and an end, e.g. 1:20-2:05")
+		}
+		c.Start = t
+	} else if c.Start, err = parseClock(startText); err != nil {
+		return "", nil, err
+	}
+	if c.End, err = parseClock(endText); err != nil {
+		return "", nil, err
+	}
+	if c.End <= c.Start {
+		return "", nil, fmt.Errorf("the clip must end after it starts (%s)", c)
+	}
+	return link, &c, nil
+}
+
+// parseClock reads a position written as seconds, m:ss or h:mm:ss.
+func parseClock(s string) (float64, error) {
+	if !clockRegex.MatchString(s) {
+		return 0, fmt.Errorf("%q is not a time, use e.g. 1:20 or 1:02:03", s)
+	}
+	var seconds float64
+	for _, part := range strings.Split(s, ":") {
+		n, _ := strconv.Atoi(part)
+		seconds = seconds*60 + float64(n)
+	}
+	return seconds, nil
+}
+
+// linkStartTime reads the t= parameter of a YouTube link.
+func linkStartTime(link string) (float64, bool) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return 0, false
+	}
+	t := u.Query().Get("t")
+	m := youtubeTimeRegex.FindStringSubmatch(t)
+	if t == "" || m == nil {
+		return 0, false
+	}
+	h, _ := strconv.Atoi(m[1])
+	min, _ := strconv.Atoi(m[2])
+	s, _ := strconv.Atoi(m[3])
+	return float64(h*3600 + min*60 + s), true
+}
+
+// String renders the range as it is written after a link.
+func (c clipRange) String() string {
+	return formatDuration(c.Start) + "-" + formatDuration(c.End)
+}
+
+// Length is the clip's duration in seconds.
+func (c clipRange) Length() float64 {
+	return c.End - c.Start
+}
+
+// appendTo writes the range after link, the form splitClip reads back.
+// Menus cache this so the range survives until a button is pressed.
+func (c *clipRange) appendTo(link string) string {
+	if c == nil {
+		return link
+	}
+	return link + " " + c.String()
+}
+
+// section is the clip as a yt-dlp --download-sections argument.
+func (c clipRange) section() string {
+	return fmt.Sprintf("*%g-%g", c.Start, c.End)
+}
+
+// cacheQuality adds the range to the quality part of a file_id key; the
+// whole video is cached under the plain quality.
+func (c *clipRange) cacheQuality(quality string) string {
+	if c == nil {
+		return quality
+	}
+	return fmt.Sprintf("%s-clip%g-%g", quality, c.Start, c.End)
+}
+
+// apply returns the metadata of the clip instead of the whole video, so size
+// estimates, fitting and usage are based on its length. A nil clip returns
+// info unchanged.
+func (c *clipRange) apply(info *VideoInfo) *VideoInfo {
+	if c == nil || info.Duration <= 0 {
+		return info
+	}
+	ratio := c.Length() / info.Duration
+	clipped := *info
+	clipped.Duration = c.Length()
+	// Chapters and subtitles cover the whole video
+	clipped.Chapters = nil
+	clipped.Subtitles, clipped.AutomaticCaptions = nil, nil
+	clipped.Formats = make([]Format, len(info.Formats))
+	for i, f := range info.Formats {
+		f.Filesize = int64(float64(f.Filesize) * ratio)
+		f.FilesizeApprox = int64(float64(f.FilesizeApprox) * ratio)
+		clipped.Formats[i] = f
+	}
+	return &clipped
+}
+
+// fit checks the clip against the video's duration, cutting an end past it.
+func (c *clipRange) fit(duration float64) error {
+	if c == nil || duration <= 0 {
+		return nil
+	}
+	if c.Start >= duration {
+		return fmt.Errorf("the clip starts after the end of the video (%s)", formatDuration(duration))
+	}
+	if c.End > duration {
+		c.End = duration
+	}
+	return nil
+}
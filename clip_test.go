@@ -0,0 +1,69 @@
+package main
+
+import "testing"
+
+func TestSplitClip(t *testing.T) {
+	tests := []struct {
+		text       string
+		link       string
+		start, end float64
+		clip, err  bool
+	}{
+		{text: "https://youtu.be/ID", link: "https://youtu.be/ID"},
+		{text: "https://youtu.be/ID?t=80", link: "https://youtu.be/ID?t=80"},
+		{text: "https://youtu.be/ID 1:20-2:05", link: "https://youtu.be/ID", start: 80, end: 125, clip: true},
+		{text: "https://youtu.be/ID 1:20 - 2:05", link: "https://youtu.be/ID", start: 80, end: 125, clip: true},
+		{text: "https://youtu.be/ID 90-1:02:03", link: "https://youtu.be/ID", start: 90, end: 3723, clip: true},
+		{text: "https://youtu.be/ID?t=80 2:05", link: "https://youtu.be/ID?t=80", start: 80, end: 125, clip: true},
+		{text: "https://www.youtube.com/watch?v=ID&t=1m20s -2:05", link: "https://www.youtube.com/watch?v=ID&t=1m20s", start: 80, end: 125, clip: true},
+		{text: "https://youtu.be/ID 2:05", err: true},
+		{text: "https://youtu.be/ID 2:05-1:20", err: true},
+		{text: "https://youtu.be/ID 1:75-2:00", err: true},
+		{text: "https://youtu.be/ID please", err: true},
+	}
+	for _, tt := range tests {
+		link, clip, err := splitClip(tt.text)
+		if tt.err {
+			if err == nil {
+				t.Errorf("splitClip(%q) = %q, %v, want an error", tt.text, link, clip)
+			}
+			continue
+		}
+		if err != nil || link != tt.link || (clip != nil) != tt.clip {
+			t.Errorf("splitClip(%q) = %q, %v, %v", tt.text, link, clip, err)
+			continue
+		}
+		if clip != nil && (clip.Start != tt.start || clip.End != tt.end) {
+			t.Errorf("splitClip(%q) range = %v-%v, want %v-%v", tt.text, clip.Start, clip.End, tt.start, tt.end)
+		}
+	}
+}
+
+func TestClipRoundTrip(t *testing.T) {
+	clip := &clipRange{Start: 80, End: 3723}
+	link, got, err := splitClip(clip.appendTo("https://youtu.be/ID"))
+	if err != nil || link != "https://youtu.be/ID" || *got != *clip {
+		t.Errorf("round trip = %q, %v, %v", link, got, err)
+	}
+	if clip.section() != "*80-3723" {
+		t.Errorf("section = %q", clip.section())
+	}
+}
+
+func TestClipScalesEstimates(t *testing.T) {
+	info := &VideoInfo{Duration: 600, Formats: []Format{{FormatID: "18", Filesize: 60_000_000}}}
+	clipped := (&clipRange{Start: 60, End: 120}).apply(info)
+	if clipped.Duration != 60 || clipped.Formats[0].Filesize != 6_000_000 {
+		t.Errorf("clipped = duration %v, size %d", clipped.Duration, clipped.Formats[0].Filesize)
+	}
+	if info.Formats[0].Filesize != 60_000_000 {
+		t.Errorf("apply changed the original info")
+	}
+	if err := (&clipRange{Start: 700, End: 800}).fit(info.Duration); err == nil {
+		t.Errorf("a clip after the end of the video was accepted")
+	}
+	long := &clipRange{Start: 500, End: 800}
+	if err := long.fit(info.Duration); err != nil || long.End != 600 {
+		t.Errorf("fit = %v, end %v, want the end cut to 600", err, long.End)
+	}
+}
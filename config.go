@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Config holds runtime settings read from the environment (.env is loaded in main).
+type Config struct {
+	Token        string
+	DownloadPath string
+	// DataPath holds state that must survive restarts (e.g. delivered file_ids).
+	DataPath string
+
+	// Workers is the number of downloads that may run at the same time.
+	Workers int
+	// QueueSize caps how many jobs may wait for a free worker.
+	QueueSize int
+
+	// URLCacheTTL is how long inline keyboard links stay valid.
+	URLCacheTTL time.Duration
+	// JobHistoryTTL is how long finished jobs are kept in the history.
+	JobHistoryTTL time.Duration
+
+	// RateLimitPerMinute caps the link messages and button presses a user
+	// may send per minute. MaxJobsPerUser caps their queued or running jobs.
+	RateLimitPerMinute int
+	MaxJobsPerUser     int
+	// DailyQuotaBytes and DailyQuotaDuration cap how much a user may download
+	// per UTC day, by size and by media length. 0 means unlimited.
+	DailyQuotaBytes    int64
+	DailyQuotaDuration time.Duration
+
+	// AdminIDs are the users allowed to run the admin commands (/stats, /ban, ...).
+	AdminIDs []int64
+
+	// Access control. Blocked users and chats are always ignored. When any
+	// allowlist or invite code is set, or PrivateMode is on, only allowed
+	// users, members of allowed chats and invited users may use the bot.
+	AllowedUsers []int64
+	AllowedChats []int64
+	BlockedUsers []int64
+	BlockedChats []int64
+	InviteCodes  []string
+	// PrivateMode silently ignores strangers instead of telling them how to join.
+	PrivateMode bool
+
+	// BotAPIURL points the bot at a self-hosted telegram-bot-api server
+	// (e.g. http://localhost:8081). Empty means the public api.telegram.org.
+	BotAPIURL string
+	// BotAPILocal says the server runs with --local, which raises the upload
+	// limit to 2GB. Without it the server keeps the public 50MB limit.
+	BotAPILocal bool
+	// BotAPILocalFiles hands a local server the path of a downloaded file
+	// instead of uploading its bytes.
+	BotAPILocalFiles bool
+}
+
+// Upload limits of the public Bot API and of a self-hosted server in --local mode.
+const (
+	publicUploadLimit = 50 * 1024 * 1024
+	localUploadLimit  = 2000 * 1024 * 1024
+)
+
+func loadConfig() (*Config, error) {
+	cfg := &Config{
+		Token:        os.Getenv("TELEGRAM_BOT_TOKEN"),
+		DownloadPath: envString("DOWNLOAD_PATH", "downloads"),
+		DataPath:     envString("DATA_PATH", "data"),
+		Workers:      envInt("DOWNLOAD_WORKERS", 2),
+		QueueSize:    envInt("DOWNLOAD_QUEUE_SIZE", 50),
+
+		URLCacheTTL:   envDuration("URL_CACHE_TTL", 7*24*time.Hour),
+		JobHistoryTTL: envDuration("JOB_HISTORY_TTL", 30*24*time.Hour),
+
+		RateLimitPerMinute: envInt("RATE_LIMIT_PER_MINUTE", 10),
+		MaxJobsPerUser:     envInt("MAX_JOBS_PER_USER", 3),
+		DailyQuotaBytes:    int64(envInt("DAILY_QUOTA_MB", 0)) * 1024 * 1024,
+		DailyQuotaDuration: envDuration("DAILY_QUOTA_DURATION", 0),
+
+		AdminIDs: envIDs("ADMIN_IDS"),
+
+		AllowedUsers: envIDs("ALLOWED_USERS"),
+		AllowedChats: envIDs("ALLOWED_CHATS"),
+		BlockedUsers: envIDs("BLOCKED_USERS"),
+		BlockedChats: envIDs("BLOCKED_CHATS"),
+		InviteCodes:  envList("INVITE_CODES"),
+		PrivateMode:  envBool("PRIVATE_MODE", false),
+
+		BotAPIURL:        strings.TrimRight(envString("TELEGRAM_API_URL", ""), "/"),
+		BotAPILocal:      envBool("TELEGRAM_API_LOCAL", false),
+		BotAPILocalFiles: envBool("TELEGRAM_API_LOCAL_FILES", true),
+	}
+	if cfg.Token == "" {
+		return nil, fmt.Errorf("TELEGRAM_BOT_TOKEN is not set")
+	}
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	if cfg.QueueSize < 1 {
+		cfg.QueueSize = 1
+	}
+	return cfg, nil
+}
+
+// UploadLimit is the largest file the configured Bot API endpoint accepts.
+func (c *Config) UploadLimit() int64 {
+	if c.localServer() {
+		return localUploadLimit
+	}
+	return publicUploadLimit
+}
+
+// localServer reports whether the bot talks to a self-hosted server in --local mode.
+func (c *Config) localServer() bool {
+	return c.BotAPIURL != "" && c.BotAPILocal
+}
+
+// APIEndpoint is the endpoint format expected by tgbotapi.NewBotAPIWithAPIEndpoint.
+func (c *Config) APIEndpoint() string {
+	if c.BotAPIURL == "" {
+		return tgbotapi.APIEndpoint
+	}
+	return c.BotAPIURL + "/bot%s/%s"
+}
+
+func envString(key, def string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+func envInt(key string, def int) int {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("Invalid value for %s (%q), using default %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+// envList splits a comma separated value, dropping empty items.
+func envList(key string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// envIDs parses a comma separated list of Telegram user or chat IDs.
+func envIDs(key string) []int64 {
+	var ids []int64
+	for _, item := range envList(key) {
+		id, err := strconv.ParseInt(item, 10, 64)
+		if err != nil {
+			log.Printf("Ignoring invalid ID %q in %s", item, key)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func envBool(key string, def bool) bool {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("Invalid value for %s (%q), using default %t", key, v, def)
+		return def
+	}
+	return b
+}
+
+// envDuration parses values like "90m" or "72h".
+func envDuration(key string, def time.Duration) time.Duration {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("Invalid value for %s (%q), using default %s", key, v, def)
+		return def
+	}
+	return d
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestUploadLimitNeedsLocalMode(t *testing.T) {
+	t.Setenv("TELEGRAM_BOT_TOKEN", "token")
+	t.Setenv("TELEGRAM_API_URL", "http://localhost:8081/")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.BotAPIURL != "http://localhost:8081" {
+		t.Errorf("BotAPIURL = %q", cfg.BotAPIURL)
+	}
+	if got := cfg.UploadLimit(); got != publicUploadLimit {
+		t.Errorf("UploadLimit without local mode = %s, want %s", formatBytes(got), formatBytes(publicUploadLimit))
+	}
+
+	t.Setenv("TELEGRAM_API_LOCAL", "true")
+	cfg, err = loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.UploadLimit(); got != localUploadLimit {
+		t.Errorf("UploadLimit in local mode = %s, want %s", formatBytes(got), formatBytes(localUploadLimit))
+	}
+}
+
+func TestUploadLimitPublicAPI(t *testing.T) {
+	t.Setenv("TELEGRAM_BOT_TOKEN", "token")
+	t.Setenv("TELEGRAM_API_URL", "")
+	t.Setenv("TELEGRAM_API_LOCAL", "true")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.UploadLimit(); got != publicUploadLimit {
+		t.Errorf("UploadLimit of the public API = %s, want %s", formatBytes(got), formatBytes(publicUploadLimit))
+	}
+}
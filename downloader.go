@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Downloader is the media backend used by the bot. The production
+// implementation shells out to yt-dlp; tests use a scripted fake.
+type Downloader interface {
+	// Available reports whether the backend is installed and usable.
+	Available() bool
+	// Info returns the metadata of a single video.
+	Info(ctx context.Context, url string) (*VideoInfo, error)
+	// PlaylistEntries lists up to max entries of a playlist (max <= 0 means all).
+	PlaylistEntries(ctx context.Context, url string, max int) ([]PlaylistEntry, error)
+	// Fetch downloads req.URL into req.Output.
+	Fetch(ctx context.Context, req FetchRequest) error
+	// Version reports the backend's version.
+	Version(ctx context.Context) (string, error)
+}
+
+// PlaylistEntry is a single item of a playlist listing.
+type PlaylistEntry struct {
+	Title    string
+	URL      string
+	Playlist string // title of the playlist
+}
+
+// FetchRequest describes one download.
+type FetchRequest struct {
+	URL     string
+	Format  string // "video" or "audio"
+	Quality string
+	Audio   string // audio format name for Format "audio", see audioFormats
+	// Container is the video container name for Format "video", see videoContainers
+	Container string
+	Output    string // full path of the file to produce
+
+	// Album and Track (1-based) are tagged into audio files downloaded as
+	// part of a playlist. Title, artist, year and cover art always are.
+	Album string
+	Track int
+	// Thumbnail also writes the video's thumbnail next to Output, see
+	// thumbnailPath. With Format "thumbnail" only the largest thumbnail is
+	// downloaded.
+	Thumbnail bool
+	// Clip, when set, downloads only that section of the video.
+	Clip *clipRange
+	// Subtitles, when set, are written next to Output (see subtitlePath) or,
+	// for Mode "soft", embedded in the video. With Format "subtitles" only
+	// the subtitles are downloaded.
+	Subtitles *subtitleRequest
+
+	// Info, when set, is the already fetched metadata of URL. It lets the
+	// backend skip extracting the page a second time.
+	Info *VideoInfo
+
+	// Progress, when set, is called as the download advances.
+	Progress func(Progress)
+}
+
+// ytDlp runs the yt-dlp binary.
+type ytDlp struct {
+	path string
+}
+
+// newYtDlp locates a working yt-dlp binary. The returned value is usable even
+// when none is found; Available reports whether the lookup succeeded.
+func newYtDlp() *ytDlp {
+	// Try multiple possible yt-dlp locations
+	ytdlpPaths := []string{
+		"yt-dlp",
+		"/usr/local/bin/yt-dlp",
+		"/usr/bin/yt-dlp",
+		".venv/bin/yt-dlp",
+	}
+
+	for _, path := range ytdlpPaths {
+		cmd := exec.Command(path, "--version")
+		if cmd.Run() == nil {
+			return &ytDlp{path: path}
+		}
+	}
+	return &ytDlp{}
+}
+
+func (y *ytDlp) Available() bool {
+	return y.path != ""
+}
+
+func (y *ytDlp) binary() string {
+	if y.path == "" {
+		return "yt-dlp"
+	}
+	return y.path
+}
+
+func (y *ytDlp) Version(ctx context.Context) (string, error) {
+	out, err := exec.CommandContext(ctx, y.binary(), "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("yt-dlp --version failed: %v", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// commonArgs are passed to every yt-dlp invocation that talks to the site.
+func (y *ytDlp) commonArgs() []string {
+	// Common args for better compatibility
+	args := []string{
+		"--no-playlist",
+		"--no-warnings",
+		"--user-agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+	}
+
+	// Add cookies if file exists (for Facebook/Instagram)
+	cookiesFile := "cookies.txt"
+	if _, err := os.Stat(cookiesFile); err == nil {
+		args = append(args, "--cookies", cookiesFile)
+	}
+	return args
+}
+
+func (y *ytDlp) Info(ctx context.Context, url string) (*VideoInfo, error) {
+	args := append([]string{"-J"}, y.commonArgs()...)
+	args = append(args, url)
+	cmd := exec.CommandContext(ctx, y.binary(), args...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s", ytDlpErrorMessage(stderr.String()))
+	}
+	info, err := parseVideoInfo(out)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata from yt-dlp: %v", err)
+	}
+	return info, nil
+}
+
+func (y *ytDlp) PlaylistEntries(ctx context.Context, url string, max int) ([]PlaylistEntry, error) {
+	args := []string{"--flat-playlist", "--no-warnings", "--print", "%(title)s||%(url)s||%(playlist_title|)s"}
+	if max > 0 {
+		args = append(args, "--playlist-end", fmt.Sprintf("%d", max))
+	}
+	args = append(args, url)
+	output, err := exec.CommandContext(ctx, y.binary(), args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("playlist fetch failed: %v - %s", err, string(output))
+	}
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	entries := make([]PlaylistEntry, 0, len(lines))
+	for _, line := range lines {
+		if max > 0 && len(entries) >= max {
+			break
+		}
+		parts := strings.SplitN(line, "||", 3)
+		if len(parts) < 2 {
+			continue
+		}
+		entry := PlaylistEntry{
+			Title: strings.TrimSpace(parts[0]),
+			URL:   strings.TrimSpace(parts[1]),
+		}
+		if len(parts) == 3 {
+			entry.Playlist = strings.TrimSpace(parts[2])
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// fetchArgs are the yt-dlp options that select and post-process what req
+// asks for, without the progress, common and input arguments.
+func fetchArgs(req FetchRequest) []string {
+	var args []string
+	if req.Format == "subtitles" {
+		args = []string{"--skip-download", "-o", req.Output}
+	} else if req.Format == "thumbnail" {
+		// Only the thumbnail, see Thumbnail below
+		args = []string{"--skip-download"}
+	} else if req.Format == "video" {
+		container, _ := lookupVideoContainer(req.Container)
+		args = []string{"-f", container.selector(req.Quality), "--merge-output-format", container.Ext, "-o", req.Output}
+		if container.Ext == "mp4" {
+			// Put the index up front so Telegram can stream the video. A final
+			// stream copy covers every download: merged, pre-muxed and clips.
+			args = append(args, "--use-postprocessor", "FFmpegCopyStream",
+				"--postprocessor-args", "CopyStream+ffmpeg_o:-movflags +faststart")
+		}
+	} else {
+		audio, _ := lookupAudioFormat(req.Audio)
+		if audio.Source != "" {
+			args = []string{"-f", audio.Source}
+		}
+		args = append(args, "-x", "--audio-format", audio.Codec, "--audio-quality", audio.audioQuality(req.Quality), "-o", req.Output)
+		// Title, artist (uploader), date and cover art for players and music apps
+		args = append(args, "--embed-metadata")
+		if audio.Cover {
+			args = append(args, "--embed-thumbnail", "--convert-thumbnails", "jpg")
+		}
+	}
+	if req.Clip != nil {
+		args = append(args, "--download-sections", req.Clip.section())
+		if req.Format == "video" {
+			// Cut exactly at the range instead of the nearest keyframes
+			args = append(args, "--force-keyframes-at-cuts")
+		}
+	}
+	if s := req.Subtitles; s != nil {
+		write := "--write-subs"
+		if s.Auto {
+			write = "--write-auto-subs"
+		}
+		args = append(args, write, "--sub-langs", s.Lang, "--sub-format", "vtt/best")
+		if req.Format == "video" && s.Mode == "soft" {
+			args = append(args, "--embed-subs")
+		} else {
+			// Written as <name>.<lang>.<ext>, see subtitlePath
+			name := strings.ReplaceAll(strings.TrimSuffix(req.Output, filepath.Ext(req.Output)), "%", "%%")
+			args = append(args, "--convert-subs", s.fileExt(), "-o", "subtitle:"+name+".%(ext)s")
+		}
+	}
+	if req.Thumbnail {
+		// The output name is a template, so escape any % in the title
+		thumb := strings.ReplaceAll(strings.TrimSuffix(req.Output, filepath.Ext(req.Output)), "%", "%%")
+		args = append(args, "--write-thumbnail", "--convert-thumbnails", "jpg", "-o", "thumbnail:"+thumb+".%(ext)s")
+	}
+	return args
+}
+
+func (y *ytDlp) Fetch(ctx context.Context, req FetchRequest) error {
+	args := fetchArgs(req)
+	args = append(args, "--newline", "--progress-template", ytDlpProgressTemplate)
+	args = append(args, y.commonArgs()...)
+
+	// Reuse the metadata we already have instead of extracting the page again
+	if req.Info != nil && len(req.Info.raw) > 0 {
+		infoFile, err := os.CreateTemp(filepath.Dir(req.Output), "info-*.json")
+		if err != nil {
+			return fmt.Errorf("failed to write info json: %v", err)
+		}
+		defer os.Remove(infoFile.Name())
+		raw := req.Info.raw
+		fields := make(map[string]interface{})
+		if req.Format == "audio" && (req.Album != "" || req.Track > 0) {
+			// yt-dlp embeds these fields of the info document as tags
+			fields["album"], fields["track_number"] = req.Album, req.Track
+		}
+		if best := bestThumbnail(req.Info); req.Format == "thumbnail" && best != nil {
+			// --write-thumbnail takes the last listed one, not the largest
+			fields["thumbnails"] = []Thumbnail{*best}
+		}
+		if len(fields) > 0 {
+			raw, err = withInfoFields(raw, fields)
+			if err != nil {
+				infoFile.Close()
+				return fmt.Errorf("failed to write info json: %v", err)
+			}
+		}
+		_, err = infoFile.Write(raw)
+		infoFile.Close()
+		if err != nil {
+			return fmt.Errorf("failed to write info json: %v", err)
+		}
+		args = append(args, "--load-info-json", infoFile.Name())
+	} else {
+		args = append(args, req.URL)
+	}
+	cmd := exec.CommandContext(ctx, y.binary(), args...)
+	killProcessTree(cmd)
+	// Don't hang on pipes held open by orphaned children after a kill
+	cmd.WaitDelay = 5 * time.Second
+
+	log.Printf("Running: %s (output: %s)", cmd.String(), req.Output)
+
+	// Stream output line by line so progress can be reported while yt-dlp runs
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start yt-dlp: %v", err)
+	}
+	waitErr := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		pw.Close()
+		waitErr <- err
+	}()
+
+	var output strings.Builder
+	scanner := bufio.NewScanner(pr)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if p, ok := parseProgressLine(line); ok {
+			if req.Progress != nil {
+				req.Progress(p)
+			}
+			continue
+		}
+		output.WriteString(line)
+		output.WriteString("\n")
+	}
+	// Drain anything left if the scanner stopped early (e.g. an overlong line)
+	io.Copy(io.Discard, pr)
+	err := <-waitErr
+
+	log.Printf("yt-dlp finished with error: %v", err)
+	log.Printf("yt-dlp output: %s", output.String())
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("%s", ytDlpErrorMessage(output.String()))
+	}
+	return nil
+}
+
+// ytDlpErrorMessage extracts a user-facing error from yt-dlp output.
+func ytDlpErrorMessage(outputStr string) string {
+	errorMsg := "Download failed"
+
+	if strings.Contains(outputStr, "ERROR:") {
+		// Find the error line
+		lines := strings.Split(outputStr, "\n")
+		for _, line := range lines {
+			if strings.Contains(line, "ERROR:") {
+				// Clean up the error message
+				errorMsg = strings.TrimSpace(strings.TrimPrefix(line, "ERROR:"))
+				// Simplify common errors
+				if strings.Contains(errorMsg, "SSL") || strings.Contains(errorMsg, "handshake") || strings.Contains(errorMsg, "timed out") {
+					errorMsg = "Connection timeout. Facebook/Instagram may be blocking downloads. Try a YouTube link instead."
+				} else if strings.Contains(errorMsg, "Unable to download webpage") {
+					errorMsg = "Cannot access this video. It may be private or region-locked."
+				} else if strings.Contains(errorMsg, "Video unavailable") {
+					errorMsg = "Video is unavailable or has been removed."
+				}
+				break
+			}
+		}
+	}
+	return errorMsg
+}
+
+func getAudioBitrate(quality string) string {
+	switch quality {
+	case "best":
+		return "0"
+	case "320":
+		return "320K"
+	case "192":
+		return "192K"
+	case "128":
+		return "128K"
+	default:
+		return "0"
+	}
+}
+
+// withInfoFields sets fields of a yt-dlp info document, skipping zero values.
+func withInfoFields(raw json.RawMessage, fields map[string]interface{}) (json.RawMessage, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	for k, v := range fields {
+		if v != "" && v != 0 {
+			doc[k] = v
+		}
+	}
+	return json.Marshal(doc)
+}
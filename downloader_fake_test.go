@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// fakeDownloader is a scripted Downloader. Videos and playlists are looked up
+// by URL; every Fetch is recorded and, unless scripted to fail, writes a small
+// file to the requested output path.
+type fakeDownloader struct {
+	mu        sync.Mutex
+	videos    map[string]*VideoInfo
+	playlists map[string][]PlaylistEntry
+	failures  map[string]error
+	blocking  map[string]chan struct{}
+	sizes     map[string]int64
+	subtitles map[string]string
+	fetches   []FetchRequest
+	infoCalls int
+}
+
+func newFakeDownloader() *fakeDownloader {
+	return &fakeDownloader{
+		videos:    make(map[string]*VideoInfo),
+		playlists: make(map[string][]PlaylistEntry),
+		failures:  make(map[string]error),
+		blocking:  make(map[string]chan struct{}),
+		sizes:     make(map[string]int64),
+		subtitles: make(map[string]string),
+	}
+}
+
+func (f *fakeDownloader) addVideo(url, id, title string) *VideoInfo {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	info := &VideoInfo{ID: id, Title: title, Uploader: "Test Channel", WebpageURL: url}
+	f.videos[url] = info
+	return info
+}
+
+func (f *fakeDownloader) addPlaylist(url string, entries ...PlaylistEntry) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.playlists[url] = entries
+}
+
+// failFetch makes every Fetch of url return err.
+func (f *fakeDownloader) failFetch(url string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[url] = err
+}
+
+// blockFetch makes a Fetch of url leave a partial file behind and hang until
+// its context is cancelled. The returned channel is closed once it started.
+func (f *fakeDownloader) blockFetch(url string) <-chan struct{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	started := make(chan struct{})
+	f.blocking[url] = started
+	return started
+}
+
+// setSize makes a Fetch of url produce a (sparse) file of size bytes.
+func (f *fakeDownloader) setSize(url string, size int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sizes[url] = size
+}
+
+// setSubtitles makes a Fetch of url write content as its subtitles.
+func (f *fakeDownloader) setSubtitles(url, content string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.subtitles[url] = content
+}
+
+func (f *fakeDownloader) fetched() []FetchRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FetchRequest(nil), f.fetches...)
+}
+
+func (f *fakeDownloader) Available() bool { return true }
+
+func (f *fakeDownloader) Version(ctx context.Context) (string, error) { return "2024.01.01-fake", nil }
+
+func (f *fakeDownloader) Info(ctx context.Context, url string) (*VideoInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.infoCalls++
+	info, ok := f.videos[url]
+	if !ok {
+		return nil, fmt.Errorf("Video is unavailable or has been removed.")
+	}
+	return info, nil
+}
+
+func (f *fakeDownloader) PlaylistEntries(ctx context.Context, url string, max int) ([]PlaylistEntry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entries, ok := f.playlists[url]
+	if !ok {
+		return nil, fmt.Errorf("unknown playlist %s", url)
+	}
+	if max > 0 && len(entries) > max {
+		entries = entries[:max]
+	}
+	return append([]PlaylistEntry(nil), entries...), nil
+}
+
+func (f *fakeDownloader) Fetch(ctx context.Context, req FetchRequest) error {
+	f.mu.Lock()
+	f.fetches = append(f.fetches, req)
+	err := f.failures[req.URL]
+	started := f.blocking[req.URL]
+	size := f.sizes[req.URL]
+	subtitles, ok := f.subtitles[req.URL]
+	f.mu.Unlock()
+	if !ok {
+		subtitles = "WEBVTT\n\n00:00:00.000 --> 00:00:02.000\nfake subtitle\n"
+	}
+
+	if err != nil {
+		return err
+	}
+	if started != nil {
+		os.WriteFile(req.Output+".part", []byte("partial"), 0644)
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if req.Progress != nil {
+		req.Progress(Progress{Stage: "downloading", Percent: 50, Downloaded: 5, Total: 10})
+		req.Progress(Progress{Stage: "merging", Percent: -1})
+	}
+	if s := req.Subtitles; s != nil && !(req.Format == "video" && s.Mode == "soft") {
+		sub := subtitlePath(req.Output, s.Lang, s.fileExt())
+		if err := os.WriteFile(sub, []byte(subtitles), 0644); err != nil {
+			return err
+		}
+	}
+	if req.Format == "subtitles" {
+		return nil
+	}
+	if req.Format != "thumbnail" {
+		if err := os.WriteFile(req.Output, []byte("fake media"), 0644); err != nil {
+			return err
+		}
+	}
+	if req.Thumbnail {
+		if err := os.WriteFile(thumbnailPath(req.Output), []byte("fake thumbnail"), 0644); err != nil {
+			return err
+		}
+	}
+	if size > 0 {
+		return os.Truncate(req.Output, size)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFetchArgsFaststart(t *testing.T) {
+	cases := []struct {
+		name string
+		req  FetchRequest
+		want bool
+	}{
+		{"merged mp4", FetchRequest{Format: "video", Quality: "720", Container: "mp4"}, true},
+		{"pre-muxed compat", FetchRequest{Format: "video", Quality: "best", Container: "compat"}, true},
+		{"mp4 clip", FetchRequest{Format: "video", Quality: "best", Container: "mp4", Clip: &clipRange{Start: 10, End: 20}}, true},
+		{"webm", FetchRequest{Format: "video", Quality: "best", Container: "webm"}, false},
+		{"audio", FetchRequest{Format: "audio", Quality: "best", Audio: "mp3"}, false},
+	}
+	for _, c := range cases {
+		args := strings.Join(fetchArgs(c.req), " ")
+		// The stream copy runs on every MP4, not only when yt-dlp merges
+		got := strings.Contains(args, "--use-postprocessor FFmpegCopyStream") &&
+			strings.Contains(args, "CopyStream+ffmpeg_o:-movflags +faststart")
+		if got != c.want {
+			t.Errorf("%s: faststart = %v, want %v (args: %s)", c.name, got, c.want, args)
+		}
+		if strings.Contains(args, "Merger+ffmpeg_o") {
+			t.Errorf("%s: faststart must not depend on the merger: %s", c.name, args)
+		}
+	}
+}
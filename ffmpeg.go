@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// MediaProcessor post-processes downloaded files. The production
+// implementation shells out to ffmpeg; tests use a scripted fake.
+type MediaProcessor interface {
+	// Available reports whether the backend is installed and usable.
+	Available() bool
+	// Split cuts the file at path into playable parts of at most limit bytes
+	// each, written next to it. The original file is left in place.
+	Split(ctx context.Context, path string, limit int64) ([]string, error)
+	// Reencode converts the video at path to an H.264/AAC MP4 with a bitrate
+	// chosen to land under limit bytes, and returns the new file's path.
+	Reencode(ctx context.Context, path string, limit int64) (string, error)
+	// MakeCompatible converts the video at path to an H.264/AAC MP4 that
+	// streams on every client, remuxing when the streams already are, and
+	// returns the new file's path.
+	MakeCompatible(ctx context.Context, path string) (string, error)
+	// Thumbnail turns the image or video at path into a Telegram thumbnail,
+	// a JPEG of at most 320px per side, and returns the new file's path.
+	// For a video it picks a representative frame.
+	Thumbnail(ctx context.Context, path string) (string, error)
+	// Probe reads the dimensions and duration of the video at path.
+	Probe(ctx context.Context, path string) (VideoProbe, error)
+	// SplitChapters cuts the file at path into one file per chapter, written
+	// next to it and tagged with the chapter's title and number. The
+	// original file is left in place.
+	SplitChapters(ctx context.Context, path string, chapters []Chapter) ([]string, error)
+	// BurnSubtitles renders the subtitle file into the video at path,
+	// re-encoding it to an H.264 MP4, and returns the new file's path.
+	BurnSubtitles(ctx context.Context, path, subtitles string) (string, error)
+}
+
+// VideoProbe is what Probe reports about a video file.
+type VideoProbe struct {
+	Width    int
+	Height   int
+	Duration float64 // seconds
+}
+
+// ffmpeg implements MediaProcessor with the ffmpeg and ffprobe binaries.
+type ffmpeg struct {
+	path  string
+	probe string
+}
+
+func newFFmpeg() *ffmpeg {
+	f := &ffmpeg{}
+	if path, err := exec.LookPath("ffmpeg"); err == nil {
+		f.path = path
+	}
+	if path, err := exec.LookPath("ffprobe"); err == nil {
+		f.probe = path
+	}
+	return f
+}
+
+func (f *ffmpeg) Available() bool {
+	return f.path != "" && f.probe != ""
+}
+
+// splitAttempts bounds how often Split retries with more, shorter parts.
+const splitAttempts = 3
+
+func (f *ffmpeg) Split(ctx context.Context, path string, limit int64) ([]string, error) {
+	if !f.Available() {
+		return nil, fmt.Errorf("ffmpeg is not installed")
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	duration, err := f.duration(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Cuts land on keyframes, so aim below the limit to leave some slack
+	parts := int(math.Ceil(float64(stat.Size()) / (float64(limit) * 0.9)))
+	for attempt := 1; attempt <= splitAttempts; attempt++ {
+		files, err := f.segment(ctx, path, duration/float64(parts))
+		if err != nil {
+			removeFiles(files)
+			return nil, err
+		}
+		if largestFile(files) <= limit {
+			log.Printf("Split %s into %d parts", path, len(files))
+			return files, nil
+		}
+		log.Printf("Split of %s into %d parts left a part over the limit, retrying", path, len(files))
+		removeFiles(files)
+		parts += parts/2 + 1
+	}
+	return nil, fmt.Errorf("could not split %s into parts under %s", filepath.Base(path), formatBytes(limit))
+}
+
+// Bitrates used when re-encoding to fit a size limit, in kbit/s.
+const (
+	fitAudioKbps    = 96
+	minFitVideoKbps = 150
+)
+
+func (f *ffmpeg) Reencode(ctx context.Context, path string, limit int64) (string, error) {
+	if !f.Available() {
+		return "", fmt.Errorf("ffmpeg is not installed")
+	}
+	duration, err := f.duration(ctx, path)
+	if err != nil {
+		return "", err
+	}
+
+	// Keep a margin for container overhead and rate control overshoot
+	videoKbps := int(float64(limit)*8*0.92/duration/1000) - fitAudioKbps
+	if videoKbps < minFitVideoKbps {
+		return "", fmt.Errorf("video is too long to fit under %s", formatBytes(limit))
+	}
+	// Low bitrates look better at a lower resolution
+	maxHeight := 1080
+	switch {
+	case videoKbps < 600:
+		maxHeight = 360
+	case videoKbps < 1200:
+		maxHeight = 480
+	case videoKbps < 2500:
+		maxHeight = 720
+	}
+
+	out := strings.TrimSuffix(path, filepath.Ext(path)) + " (fitted).mp4"
+	args := []string{
+		"-hide_banner", "-loglevel", "error", "-y",
+		"-i", path,
+		"-vf", fmt.Sprintf("scale=-2:'min(%d,ih)'", maxHeight),
+		"-c:v", "libx264", "-preset", "veryfast",
+		"-b:v", fmt.Sprintf("%dk", videoKbps),
+		"-maxrate", fmt.Sprintf("%dk", videoKbps),
+		"-bufsize", fmt.Sprintf("%dk", videoKbps*2),
+		"-c:a", "aac", "-b:a", fmt.Sprintf("%dk", fitAudioKbps),
+		"-movflags", "+faststart",
+		out,
+	}
+	cmd := exec.CommandContext(ctx, f.path, args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(out)
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("ffmpeg re-encode failed: %v - %s", err, strings.TrimSpace(string(output)))
+	}
+	if largestFile([]string{out}) > limit {
+		os.Remove(out)
+		return "", fmt.Errorf("re-encoded video is still over %s", formatBytes(limit))
+	}
+	log.Printf("Re-encoded %s at %dk (max %dp)", path, videoKbps, maxHeight)
+	return out, nil
+}
+
+func (f *ffmpeg) MakeCompatible(ctx context.Context, path string) (string, error) {
+	if !f.Available() {
+		return "", fmt.Errorf("ffmpeg is not installed")
+	}
+	codecs, err := f.codecs(ctx, path)
+	if err != nil {
+		return "", err
+	}
+
+	// Copy what is already compatible, re-encode the rest
+	video, audio := []string{"-c:v", "copy"}, []string{"-c:a", "copy"}
+	if codecs["video"] != "h264" {
+		video = []string{"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p"}
+	}
+	if codecs["audio"] != "aac" {
+		audio = []string{"-c:a", "aac", "-b:a", "160k"}
+	}
+
+	out := strings.TrimSuffix(path, filepath.Ext(path)) + " (compatible).mp4"
+	args := []string{"-hide_banner", "-loglevel", "error", "-y", "-i", path, "-map", "0:v:0", "-map", "0:a:0?"}
+	args = append(args, video...)
+	args = append(args, audio...)
+	args = append(args, "-movflags", "+faststart", out)
+	cmd := exec.CommandContext(ctx, f.path, args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(out)
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("ffmpeg conversion failed: %v - %s", err, strings.TrimSpace(string(output)))
+	}
+	log.Printf("Converted %s to H.264/AAC (video %s, audio %s)", path, codecs["video"], codecs["audio"])
+	return out, nil
+}
+
+// Telegram's limits for thumbnails.
+const (
+	thumbMaxSide  = 320
+	thumbMaxBytes = 200 << 10
+)
+
+func (f *ffmpeg) Thumbnail(ctx context.Context, path string) (string, error) {
+	if !f.Available() {
+		return "", fmt.Errorf("ffmpeg is not installed")
+	}
+	out := strings.TrimSuffix(path, filepath.Ext(path)) + " (thumb).jpg"
+	cmd := exec.CommandContext(ctx, f.path,
+		"-hide_banner", "-loglevel", "error", "-y",
+		"-i", path,
+		// thumbnail picks a representative frame; an image has just the one
+		"-vf", fmt.Sprintf("thumbnail,scale=%d:%d:force_original_aspect_ratio=decrease", thumbMaxSide, thumbMaxSide),
+		"-frames:v", "1", "-q:v", "4",
+		out,
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(out)
+		return "", fmt.Errorf("ffmpeg thumbnail failed: %v - %s", err, strings.TrimSpace(string(output)))
+	}
+	if largestFile([]string{out}) > thumbMaxBytes {
+		os.Remove(out)
+		return "", fmt.Errorf("thumbnail is over %s", formatBytes(thumbMaxBytes))
+	}
+	return out, nil
+}
+
+func (f *ffmpeg) Probe(ctx context.Context, path string) (VideoProbe, error) {
+	if !f.Available() {
+		return VideoProbe{}, fmt.Errorf("ffmpeg is not installed")
+	}
+	out, err := exec.CommandContext(ctx, f.probe,
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=width,height:format=duration",
+		"-of", "json",
+		path,
+	).Output()
+	if err != nil {
+		return VideoProbe{}, fmt.Errorf("ffprobe failed: %v", err)
+	}
+	var result struct {
+		Streams []struct {
+			Width  int `json:"width"`
+			Height int `json:"height"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return VideoProbe{}, fmt.Errorf("invalid ffprobe output: %v", err)
+	}
+	if len(result.Streams) == 0 {
+		return VideoProbe{}, fmt.Errorf("no video stream in %s", filepath.Base(path))
+	}
+	p := VideoProbe{Width: result.Streams[0].Width, Height: result.Streams[0].Height}
+	p.Duration, _ = strconv.ParseFloat(result.Format.Duration, 64)
+	return p, nil
+}
+
+func (f *ffmpeg) SplitChapters(ctx context.Context, path string, chapters []Chapter) ([]string, error) {
+	if !f.Available() {
+		return nil, fmt.Errorf("ffmpeg is not installed")
+	}
+	ext := filepath.Ext(path)
+	var files []string
+	for i, ch := range chapters {
+		out := fmt.Sprintf("%s - chapter%02d%s", strings.TrimSuffix(path, ext), i+1, ext)
+		args := []string{"-hide_banner", "-loglevel", "error", "-y",
+			"-ss", strconv.FormatFloat(ch.StartTime, 'f', 3, 64)}
+		if ch.EndTime > ch.StartTime {
+			args = append(args, "-to", strconv.FormatFloat(ch.EndTime, 'f', 3, 64))
+		}
+		args = append(args,
+			"-i", path,
+			"-map", "0", "-c", "copy", "-avoid_negative_ts", "make_zero",
+			"-metadata", "title="+ch.Title,
+			"-metadata", fmt.Sprintf("track=%d/%d", i+1, len(chapters)),
+			out,
+		)
+		cmd := exec.CommandContext(ctx, f.path, args...)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			os.Remove(out)
+			if ctx.Err() != nil {
+				return files, ctx.Err()
+			}
+			return files, fmt.Errorf("ffmpeg chapter split failed: %v - %s", err, strings.TrimSpace(string(output)))
+		}
+		files = append(files, out)
+	}
+	return files, nil
+}
+
+func (f *ffmpeg) BurnSubtitles(ctx context.Context, path, subtitles string) (string, error) {
+	if !f.Available() {
+		return "", fmt.Errorf("ffmpeg is not installed")
+	}
+	// The subtitles filter parses its argument, so hand it a name with
+	// nothing to escape, relative to the working directory
+	tmp, err := os.CreateTemp(filepath.Dir(subtitles), "subs-*"+filepath.Ext(subtitles))
+	if err != nil {
+		return "", err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if err := os.Rename(subtitles, tmp.Name()); err != nil {
+		return "", err
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	out := strings.TrimSuffix(abs, filepath.Ext(abs)) + " (subtitled).mp4"
+	cmd := exec.CommandContext(ctx, f.path,
+		"-hide_banner", "-loglevel", "error", "-y",
+		"-i", abs,
+		"-vf", "subtitles="+filepath.Base(tmp.Name()),
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p",
+		"-c:a", "aac", "-b:a", "160k",
+		"-movflags", "+faststart",
+		out,
+	)
+	cmd.Dir = filepath.Dir(tmp.Name())
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(out)
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("ffmpeg subtitle burn-in failed: %v - %s", err, strings.TrimSpace(string(output)))
+	}
+	log.Printf("Burned %s into %s", filepath.Base(subtitles), path)
+	return out, nil
+}
+
+// codecs reads the codec of the first video and audio stream with ffprobe,
+// keyed by "video" and "audio".
+func (f *ffmpeg) codecs(ctx context.Context, path string) (map[string]string, error) {
+	out, err := exec.CommandContext(ctx, f.probe,
+		"-v", "error",
+		"-show_entries", "stream=codec_type,codec_name",
+		"-of", "csv=p=0",
+		path,
+	).Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %v", err)
+	}
+	codecs := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		// Lines are "codec_name,codec_type"
+		name, kind, ok := strings.Cut(strings.TrimSpace(line), ",")
+		if ok && codecs[kind] == "" {
+			codecs[kind] = name
+		}
+	}
+	return codecs, nil
+}
+
+// segment runs ffmpeg's segment muxer, cutting path into pieces of about
+// seconds each without re-encoding. Parts are named "<name> - partNN<ext>".
+func (f *ffmpeg) segment(ctx context.Context, path string, seconds float64) ([]string, error) {
+	ext := filepath.Ext(path)
+	prefix := strings.TrimSuffix(path, ext) + " - part"
+	// The output name is a printf pattern, so escape any % in the title
+	pattern := strings.ReplaceAll(prefix, "%", "%%") + "%02d" + ext
+
+	args := []string{
+		"-hide_banner", "-loglevel", "error", "-y",
+		"-i", path,
+		"-map", "0", "-c", "copy",
+		"-f", "segment",
+		"-segment_time", strconv.FormatFloat(seconds, 'f', 3, 64),
+		"-reset_timestamps", "1",
+		pattern,
+	}
+	cmd := exec.CommandContext(ctx, f.path, args...)
+	out, err := cmd.CombinedOutput()
+
+	var files []string
+	for i := 0; ; i++ {
+		name := fmt.Sprintf("%s%02d%s", prefix, i, ext)
+		if _, statErr := os.Stat(name); statErr != nil {
+			break
+		}
+		files = append(files, name)
+	}
+	if ctx.Err() != nil {
+		return files, ctx.Err()
+	}
+	if err != nil {
+		return files, fmt.Errorf("ffmpeg split failed: %v - %s", err, strings.TrimSpace(string(out)))
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("ffmpeg produced no parts")
+	}
+	return files, nil
+}
+
+// duration reads the length of a media file in seconds with ffprobe.
+func (f *ffmpeg) duration(ctx context.Context, path string) (float64, error) {
+	out, err := exec.CommandContext(ctx, f.probe,
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		path,
+	).Output()
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe failed: %v", err)
+	}
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("could not read duration of %s", filepath.Base(path))
+	}
+	return seconds, nil
+}
+
+func largestFile(files []string) int64 {
+	var largest int64
+	for _, name := range files {
+		if stat, err := os.Stat(name); err == nil && stat.Size() > largest {
+			largest = stat.Size()
+		}
+	}
+	return largest
+}
+
+func removeFiles(files []string) {
+	for _, name := range files {
+		os.Remove(name)
+	}
+}
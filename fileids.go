@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"log"
+)
+
+var errSendFailed = errors.New("failed to send file")
+
+// mediaKey identifies a delivered file independent of who asked for it.
+func mediaKey(videoID, format, quality string) string {
+	return videoID + "|" + format + "|" + quality
+}
+
+// cachedFileID returns the Telegram file_id of media the bot uploaded before,
+// so repeat requests can be answered without downloading again.
+func (b *Bot) cachedFileID(key string) (string, bool) {
+	var fileID string
+	ok, err := b.store.Get(bucketFileIDs, key, &fileID)
+	if err != nil {
+		log.Printf("file_id lookup for %s failed: %v", key, err)
+	}
+	return fileID, ok
+}
+
+func (b *Bot) rememberFileID(key, fileID string) {
+	if err := b.store.Put(bucketFileIDs, key, fileID, 0); err != nil {
+		log.Printf("Failed to store file_id for %s: %v", key, err)
+	}
+}
+
+func (b *Bot) forgetFileID(key string) {
+	b.store.Delete(bucketFileIDs, key)
+}
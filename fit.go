@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+)
+
+// fitQuality is the quality token of the "Fit to Telegram" menu option: the
+// best video that fits the upload limit, re-encoded if no format is small enough.
+const fitQuality = "fit"
+
+// fitPlan is how a "Fit to Telegram" request is served.
+type fitPlan struct {
+	Quality  string // quality token passed to the downloader
+	Label    string // resolution shown to the user, e.g. "480p"
+	Limit    int64
+	Reencode bool // no format fits, so the download is re-encoded
+}
+
+// planFit picks the highest resolution whose estimated size fits limit. When
+// none does, the smallest one is downloaded and re-encoded to a lower bitrate.
+func planFit(info *VideoInfo, limit int64) fitPlan {
+	options := videoOptions(info)
+	if len(options) == 0 {
+		// No format list: try the best and re-encode if it turns out too large
+		return fitPlan{Quality: "best", Label: "best available", Limit: limit}
+	}
+	var known bool
+	for _, o := range options {
+		if o.Size > 0 {
+			known = true
+			if o.Size <= limit {
+				return fitPlan{Quality: o.Quality, Label: fmt.Sprintf("%dp", o.Height), Limit: limit}
+			}
+		}
+	}
+	if !known {
+		return fitPlan{Quality: options[0].Quality, Label: fmt.Sprintf("%dp", options[0].Height), Limit: limit}
+	}
+	smallest := options[len(options)-1]
+	return fitPlan{Quality: smallest.Quality, Label: fmt.Sprintf("%dp", smallest.Height), Limit: limit, Reencode: true}
+}
+
+// note explains which quality is delivered.
+func (p fitPlan) note() string {
+	if p.Reencode {
+		return fmt.Sprintf("📦 Fit to Telegram: %s, re-encoded to fit under %s", p.Label, formatBytes(p.Limit))
+	}
+	return fmt.Sprintf("📦 Fit to Telegram: %s, the best quality under %s", p.Label, formatBytes(p.Limit))
+}
+
+// cacheQuality is the quality part of the file_id key; the result depends on the limit.
+func (p fitPlan) cacheQuality() string {
+	return fmt.Sprintf("%s-%d", fitQuality, p.Limit)
+}
+
+// reencodeToFit re-encodes a downloaded video that is still over the plan's
+// limit and returns the path of the file to send.
+func (b *Bot) reencodeToFit(ctx context.Context, filePath string, plan *fitPlan, progress func(Progress)) (string, error) {
+	stat, err := os.Stat(filePath)
+	if err != nil || stat.Size() <= plan.Limit {
+		return filePath, err
+	}
+	if progress != nil {
+		progress(Progress{Stage: "re-encoding", Percent: -1})
+	}
+	log.Printf("Re-encoding %s (%s) to fit %s", filePath, formatBytes(stat.Size()), formatBytes(plan.Limit))
+	fitted, err := b.media.Reencode(ctx, filePath, plan.Limit)
+	if err != nil {
+		return filePath, err
+	}
+	os.Remove(filePath)
+	plan.Reencode = true
+	return fitted, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// JobRecord is the stored outcome of a finished job.
+type JobRecord struct {
+	ChatID   int64     `json:"chat_id"`
+	UserID   int64     `json:"user_id"`
+	URL      string    `json:"url"`
+	Format   string    `json:"format"`
+	Quality  string    `json:"quality"`
+	Playlist bool      `json:"playlist,omitempty"`
+	Status   string    `json:"status"` // "done", "failed" or "cancelled"
+	Error    string    `json:"error,omitempty"`
+	Enqueued time.Time `json:"enqueued"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+}
+
+// recordJob adds a finished job to the job history.
+func (b *Bot) recordJob(job *Job, jobErr error) {
+	rec := JobRecord{
+		ChatID:   job.ChatID,
+		UserID:   job.UserID,
+		URL:      job.URL,
+		Format:   job.Format,
+		Quality:  job.Quality,
+		Playlist: job.Playlist,
+		Status:   "done",
+		Enqueued: job.Enqueued,
+		Started:  job.Started,
+		Finished: time.Now(),
+	}
+	switch {
+	case errors.Is(jobErr, context.Canceled):
+		rec.Status = "cancelled"
+	case jobErr != nil:
+		rec.Status = "failed"
+		rec.Error = jobErr.Error()
+	}
+	// Job IDs restart at 1 with every process, so key by time to keep history ordered
+	key := fmt.Sprintf("%020d-%d", rec.Finished.UnixNano(), job.ID)
+	if err := b.store.Put(bucketJobs, key, rec, b.config().JobHistoryTTL); err != nil {
+		log.Printf("Failed to record job %d: %v", job.ID, err)
+	}
+}
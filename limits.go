@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// rateLimiter allows each user at most limit requests per sliding window.
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	hits   map[int64][]time.Time
+	pruned time.Time // last time users with no recent requests were dropped
+	now    func() time.Time
+}
+
+// newRateLimiter returns a limiter; a limit of 0 or less disables it.
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:  limit,
+		window: window,
+		hits:   make(map[int64][]time.Time),
+		now:    time.Now,
+	}
+}
+
+// Allow records a request by userID. When the user is over the limit it
+// returns false and how long until the next request is allowed.
+func (r *rateLimiter) Allow(userID int64) (bool, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.limit <= 0 {
+		return true, 0
+	}
+
+	now := r.now()
+	if now.Sub(r.pruned) >= r.window {
+		r.prune(now)
+	}
+	// Forget requests that left the window
+	hits := r.hits[userID]
+	for len(hits) > 0 && now.Sub(hits[0]) >= r.window {
+		hits = hits[1:]
+	}
+	if len(hits) >= r.limit {
+		r.hits[userID] = hits
+		return false, hits[0].Add(r.window).Sub(now)
+	}
+	r.hits[userID] = append(hits, now)
+	return true, 0
+}
+
+// prune drops users whose requests all left the window, so the map only
+// holds recently active users.
+func (r *rateLimiter) prune(now time.Time) {
+	for userID, hits := range r.hits {
+		if len(hits) == 0 || now.Sub(hits[len(hits)-1]) >= r.window {
+			delete(r.hits, userID)
+		}
+	}
+	r.pruned = now
+}
+
+// SetLimit changes the limit, keeping the recorded requests.
+func (r *rateLimiter) SetLimit(limit int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.limit = limit
+}
+
+// DailyUsage is what a user downloaded on one (UTC) day.
+type DailyUsage struct {
+	Bytes   int64   `json:"bytes"`
+	Seconds float64 `json:"seconds"` // media duration
+}
+
+func usageKey(userID int64, day time.Time) string {
+	return fmt.Sprintf("%d-%s", userID, day.UTC().Format("2006-01-02"))
+}
+
+// dailyUsage returns what userID downloaded today.
+func (b *Bot) dailyUsage(userID int64) DailyUsage {
+	var u DailyUsage
+	if _, err := b.store.Get(bucketUsage, usageKey(userID, time.Now()), &u); err != nil {
+		log.Printf("Failed to load usage for user %d: %v", userID, err)
+	}
+	return u
+}
+
+// addUsage adds a finished download to the user's usage for today.
+func (b *Bot) addUsage(userID int64, bytes int64, seconds float64) {
+	b.usageMu.Lock()
+	defer b.usageMu.Unlock()
+	u := b.dailyUsage(userID)
+	u.Bytes += bytes
+	u.Seconds += seconds
+	// Two days so the record outlives the day in every timezone
+	if err := b.store.Put(bucketUsage, usageKey(userID, time.Now()), u, 48*time.Hour); err != nil {
+		log.Printf("Failed to record usage for user %d: %v", userID, err)
+	}
+}
+
+// quotaExceeded reports whether the user used up a daily quota, with a
+// message explaining when it resets.
+func (b *Bot) quotaExceeded(userID int64) (string, bool) {
+	u := b.dailyUsage(userID)
+	var used string
+	switch {
+	case b.config().DailyQuotaBytes > 0 && u.Bytes >= b.config().DailyQuotaBytes:
+		used = formatBytes(b.config().DailyQuotaBytes)
+	case b.config().DailyQuotaDuration > 0 && u.Seconds >= b.config().DailyQuotaDuration.Seconds():
+		used = fmt.Sprintf("%s of media", b.config().DailyQuotaDuration)
+	default:
+		return "", false
+	}
+	return fmt.Sprintf("📊 You've reached today's download quota (%s). It resets in %s.", used, formatWait(untilMidnightUTC(time.Now()))), true
+}
+
+// checkLimits applies the per-user limits to a request that starts new work.
+// It returns a message for the user when the request must be refused.
+func (b *Bot) checkLimits(userID int64, startsDownload bool) (string, bool) {
+	if ok, wait := b.limiter.Allow(userID); !ok {
+		return fmt.Sprintf("🐢 Slow down! You can make %d requests per minute. Try again in %s.", b.config().RateLimitPerMinute, formatWait(wait)), false
+	}
+	if !startsDownload {
+		return "", true
+	}
+	if max := b.config().MaxJobsPerUser; max > 0 && b.jobs.UserJobs(userID) >= max {
+		return fmt.Sprintf("⏳ You can have at most %d downloads queued or running. Try again when one has finished.", max), false
+	}
+	if msg, exceeded := b.quotaExceeded(userID); exceeded {
+		return msg, false
+	}
+	return "", true
+}
+
+func untilMidnightUTC(now time.Time) time.Duration {
+	now = now.UTC()
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return midnight.Sub(now)
+}
+
+// formatWait renders a wait time for humans, rounded up to whole seconds or minutes.
+func formatWait(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%ds", int((d+time.Second-1)/time.Second))
+	}
+	d = (d + time.Minute - 1).Truncate(time.Minute)
+	if d < time.Hour {
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+	return fmt.Sprintf("%dh %dm", int(d.Hours()), int(d.Minutes())%60)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	r := newRateLimiter(2, time.Minute)
+	r.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := r.Allow(1); !ok {
+			t.Fatalf("request %d refused", i+1)
+		}
+	}
+	ok, wait := r.Allow(1)
+	if ok || wait != time.Minute {
+		t.Fatalf("third request: ok=%v wait=%s, want refused for 1m", ok, wait)
+	}
+	if ok, _ := r.Allow(2); !ok {
+		t.Error("limits must be per user")
+	}
+
+	now = now.Add(time.Minute)
+	if ok, _ := r.Allow(1); !ok {
+		t.Error("request refused after the window passed")
+	}
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	r := newRateLimiter(0, time.Minute)
+	for i := 0; i < 100; i++ {
+		if ok, _ := r.Allow(1); !ok {
+			t.Fatal("a zero limit must not refuse requests")
+		}
+	}
+}
+
+func TestFormatWait(t *testing.T) {
+	cases := map[time.Duration]string{
+		1500 * time.Millisecond:      "2s",
+		90 * time.Second:             "2m",
+		5*time.Hour + 30*time.Second: "5h 1m",
+	}
+	for d, want := range cases {
+		if got := formatWait(d); got != want {
+			t.Errorf("formatWait(%s) = %q, want %q", d, got, want)
+		}
+	}
+}
+
+func TestRateLimiterPrunesIdleUsers(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	r := newRateLimiter(2, time.Minute)
+	r.now = func() time.Time { return now }
+
+	for userID := int64(1); userID <= 100; userID++ {
+		r.Allow(userID)
+	}
+	now = now.Add(30 * time.Second)
+	r.Allow(1)
+
+	now = now.Add(45 * time.Second)
+	r.Allow(2)
+	if len(r.hits) != 2 {
+		t.Errorf("%d users tracked, want only the 2 with requests in the window", len(r.hits))
+	}
+}
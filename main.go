@@ -4,14 +4,19 @@ import (
 	"context"
 	"crypto/md5"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -24,10 +29,17 @@ var (
 )
 
 type Bot struct {
-	api          *tgbotapi.BotAPI
+	api          Messenger
+	dl           Downloader
+	media        MediaProcessor
+	cfg          atomic.Pointer[Config] // swapped by /reload; read it with config()
+	jobs         *JobQueue
+	store        Store
+	infos        *infoCache
+	limiter      *rateLimiter
+	usageMu      sync.Mutex // serializes daily usage updates
+	started      time.Time
 	downloadPath string
-	urlCache     map[string]string
-	cacheMutex   sync.RWMutex
 }
 
 func main() {
@@ -36,110 +48,136 @@ func main() {
 		log.Println("No .env file found, using system environment variables")
 	}
 
-	token := os.Getenv("TELEGRAM_BOT_TOKEN")
-	if token == "" {
-		log.Fatal("TELEGRAM_BOT_TOKEN is not set")
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	bot, err := tgbotapi.NewBotAPI(token)
+	bot, err := tgbotapi.NewBotAPIWithAPIEndpoint(cfg.Token, cfg.APIEndpoint())
 	if err != nil {
 		log.Panic(err)
 	}
 
 	bot.Debug = true
 	log.Printf("Authorized on account %s", bot.Self.UserName)
+	if cfg.BotAPIURL != "" {
+		log.Printf("Using Bot API server %s (local mode: %t, upload limit %s, local files: %t)", cfg.BotAPIURL, cfg.BotAPILocal, formatBytes(cfg.UploadLimit()), cfg.localServer() && cfg.BotAPILocalFiles)
+	}
 
-	// Create downloads directory
-	downloadPath := "downloads"
-	if err := os.MkdirAll(downloadPath, 0755); err != nil {
-		log.Fatal(err)
+	// Create downloads and data directories
+	for _, dir := range []string{cfg.DownloadPath, cfg.DataPath} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	mediaBot := &Bot{
-		api:          bot,
-		downloadPath: downloadPath,
-		urlCache:     make(map[string]string),
+	store, err := openFileStore(filepath.Join(cfg.DataPath, "store.json"))
+	if err != nil {
+		log.Fatal(err)
 	}
+	defer store.Close()
+
+	mediaBot := newBot(bot, newYtDlp(), store, cfg)
 
 	// Register bot commands (makes the bot interface modern in Telegram clients)
 	commands := []tgbotapi.BotCommand{
 		{Command: "start", Description: "Start the bot and show welcome"},
 		{Command: "help", Description: "Show help and usage"},
 		{Command: "latest", Description: "Show latest features"},
+		{Command: "settings", Description: "Default format, quality and delivery options"},
+		{Command: "transcript", Description: "Captions of a video as text"},
 	}
-	if _, err := bot.Request(tgbotapi.NewSetMyCommands(commands)); err != nil {
+	if _, err := bot.Request(tgbotapi.NewSetMyCommands(commands...)); err != nil {
 		log.Printf("Failed to set bot commands: %v", err)
 	}
+	// Admins additionally see the admin commands in their private chat
+	adminCommands := append(commands,
+		tgbotapi.BotCommand{Command: "stats", Description: "Usage statistics"},
+		tgbotapi.BotCommand{Command: "queue", Description: "Running and waiting downloads"},
+		tgbotapi.BotCommand{Command: "ban", Description: "Ban a user by ID"},
+		tgbotapi.BotCommand{Command: "unban", Description: "Unban a user by ID"},
+		tgbotapi.BotCommand{Command: "broadcast", Description: "Message every known chat"},
+		tgbotapi.BotCommand{Command: "diskusage", Description: "Disk space used and free"},
+		tgbotapi.BotCommand{Command: "cleanup", Description: "Remove leftover downloads"},
+		tgbotapi.BotCommand{Command: "ytdlpversion", Description: "Show the yt-dlp version"},
+		tgbotapi.BotCommand{Command: "reload", Description: "Reload the configuration"},
+	)
+	for _, adminID := range cfg.AdminIDs {
+		scope := tgbotapi.NewBotCommandScopeChat(adminID)
+		if _, err := bot.Request(tgbotapi.NewSetMyCommandsWithScope(scope, adminCommands...)); err != nil {
+			log.Printf("Failed to set admin commands for %d: %v", adminID, err)
+		}
+	}
 
 	// Check if yt-dlp is installed
-	if !mediaBot.checkYtDlp() {
+	if !mediaBot.dl.Available() {
 		log.Fatal("yt-dlp is not installed. Please install it: https://github.com/yt-dlp/yt-dlp")
 	}
+	if !mediaBot.media.Available() {
+		log.Println("ffmpeg/ffprobe not found: files over the upload limit cannot be split or re-encoded")
+	}
+
+	// Downloads run on the worker pool so the update loop never blocks on yt-dlp
+	mediaBot.jobs.Start(cfg.Workers, mediaBot.runJob)
 
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
 
-	updates := bot.GetUpdatesChan(u)
-
-	for update := range updates {
-		if update.CallbackQuery != nil {
-			mediaBot.handleCallbackQuery(update.CallbackQuery)
-			continue
-		}
-
-		if update.Message == nil {
-			continue
-		}
-
-		if update.Message.IsCommand() {
-			mediaBot.handleCommand(update.Message)
-		} else {
-			mediaBot.handleMessage(update.Message)
-		}
-	}
+	// Stop polling on Ctrl+C / SIGTERM, then cancel the downloads (yt-dlp runs
+	// in its own process group and misses the signal) and wait for them before
+	// the store gets flushed on the way out
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		log.Println("Shutting down...")
+		bot.StopReceivingUpdates()
+	}()
+
+	mediaBot.handleUpdates(bot.GetUpdatesChan(u))
+	mediaBot.jobs.Close()
 }
 
-func (b *Bot) checkYtDlp() bool {
-	// Try multiple possible yt-dlp locations
-	ytdlpPaths := []string{
-		"yt-dlp",
-		"/usr/local/bin/yt-dlp",
-		"/usr/bin/yt-dlp",
-		".venv/bin/yt-dlp",
-	}
-
-	for _, path := range ytdlpPaths {
-		cmd := exec.Command(path, "--version")
-		if cmd.Run() == nil {
-			return true
-		}
+func newBot(api Messenger, dl Downloader, store Store, cfg *Config) *Bot {
+	b := &Bot{
+		api:          api,
+		dl:           dl,
+		media:        newFFmpeg(),
+		jobs:         NewJobQueue(cfg.QueueSize),
+		store:        store,
+		infos:        newInfoCache(),
+		limiter:      newRateLimiter(cfg.RateLimitPerMinute, time.Minute),
+		started:      time.Now(),
+		downloadPath: cfg.DownloadPath,
 	}
-	return false
+	b.jobs.dropped = b.dropJob
+	b.cfg.Store(cfg)
+	return b
 }
 
-func (b *Bot) getYtDlpPath() string {
-	ytdlpPaths := []string{
-		"yt-dlp",
-		"/usr/local/bin/yt-dlp",
-		"/usr/bin/yt-dlp",
-		".venv/bin/yt-dlp",
-	}
-
-	for _, path := range ytdlpPaths {
-		cmd := exec.Command(path, "--version")
-		if cmd.Run() == nil {
-			return path
-		}
-	}
-	return "yt-dlp"
+// config returns the current configuration.
+func (b *Bot) config() *Config {
+	return b.cfg.Load()
 }
 
 func (b *Bot) handleCommand(message *tgbotapi.Message) {
+	if b.isAdmin(message.From) && b.handleAdminCommand(message) {
+		return
+	}
+
 	switch message.Command() {
 	case "start":
 		b.sendWelcomeMessage(message.Chat.ID)
 	case "help":
 		b.sendHelpMessage(message.Chat.ID)
+	case "latest":
+		b.sendLatestMessage(message.Chat.ID)
+	case "settings":
+		if message.From != nil {
+			b.sendSettings(message.Chat.ID, message.From.ID)
+		}
+	case "transcript":
+		b.handleTranscriptCommand(message)
 	default:
 		msg := tgbotapi.NewMessage(message.Chat.ID, "Unknown command. Use /help for available commands.")
 		b.api.Send(msg)
@@ -202,6 +240,21 @@ func (b *Bot) sendHelpMessage(chatID int64) {
 4. Wait for the download to complete
 5. Receive your media file!
 
+*Clips:*
+• Add a time range after the link to get only that part, e.g. ` + "`https://youtu.be/ID 1:20-2:05`" + `
+• Or use a link with t= and add the end time, e.g. ` + "`https://youtu.be/ID?t=80 2:05`" + `
+
+*Chapters:*
+• Videos with chapters offer "📑 chapters as video/audio": one numbered file per chapter, after an index
+
+*Subtitles:*
+• "📝 Subtitles" lists the video's subtitle and caption languages
+• Get them as an SRT or VTT file, or in an MP4 as a subtitle track or burned into the picture
+
+*Transcripts:*
+• /transcript <url> sends the captions of a video as plain text; add "timestamps" to start each paragraph with its time
+• Or press "🧾 Transcript" in the quality menu
+
 *Playlist Options:*
 • Download as single video (if playlist link)
 • Download first 5 videos from playlist
@@ -209,6 +262,8 @@ func (b *Bot) sendHelpMessage(chatID int64) {
 *Commands:*
 /start - Start the bot
 /help - Show this help message
+/settings - Choose your default format, quality and delivery options
+/transcript <url> - Get the text of a video
 
 *Note:* Large files may take time to process. Please be patient! 🙏`
 
@@ -217,6 +272,25 @@ func (b *Bot) sendHelpMessage(chatID int64) {
 	b.api.Send(msg)
 }
 
+func (b *Bot) sendLatestMessage(chatID int64) {
+	text := `✨ *Latest features*
+
+• Quality menu built from each video's real formats, with estimated sizes
+• Live download progress and a cancel button
+• Files over the upload limit are split into parts
+• 📦 Fit to Telegram: best quality that fits the size limit
+• Instant re-sends of media that was delivered before
+• ✂️ Clips: add a time range after the link, e.g. 1:20-2:05
+• 📑 Split videos with chapters into one file per chapter
+• 📝 Subtitles as SRT/VTT files or inside the video
+• 🧾 /transcript: the text of a video, optionally with timestamps
+• 🖼 Thumbnail in full resolution, as a photo and an uncompressed file`
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = "Markdown"
+	b.api.Send(msg)
+}
+
 func (b *Bot) handleMessage(message *tgbotapi.Message) {
 	text := strings.TrimSpace(message.Text)
 
@@ -227,16 +301,48 @@ func (b *Bot) handleMessage(message *tgbotapi.Message) {
 		return
 	}
 
+	// A time range after the link asks for a clip
+	link, clip, err := splitClip(text)
+	if err != nil {
+		b.api.Send(tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("❌ Couldn't read the time range: %v.", err)))
+		return
+	}
+
 	// Detect platform
-	platform := b.detectPlatform(text)
+	platform := b.detectPlatform(link)
 	if platform == "" {
 		msg := tgbotapi.NewMessage(message.Chat.ID, "❌ Unsupported link. Please send a YouTube video or playlist link.")
 		b.api.Send(msg)
 		return
 	}
+	if clip != nil && platform == "youtube-playlist" {
+		b.api.Send(tgbotapi.NewMessage(message.Chat.ID, "❌ Clips work on single videos. Please send the video's own link with the time range."))
+		return
+	}
+
+	if message.From != nil {
+		// Users who skip the menu get a single video with their defaults right away
+		s := b.userSettings(message.From.ID).withDefaults()
+		auto := s.AutoDownload && platform == "youtube"
+		if reply, ok := b.checkLimits(message.From.ID, auto); !ok {
+			b.api.Send(tgbotapi.NewMessage(message.Chat.ID, reply))
+			return
+		}
+		if auto {
+			b.enqueueJob(nil, &Job{
+				ChatID:  message.Chat.ID,
+				UserID:  message.From.ID,
+				URL:     link,
+				Format:  s.Format,
+				Quality: s.Quality,
+				Clip:    clip,
+			})
+			return
+		}
+	}
 
 	// Send quality selection keyboard
-	b.sendQualityOptions(message.Chat.ID, text, platform)
+	b.sendQualityOptions(message.Chat.ID, clip.appendTo(link), platform)
 }
 
 func (b *Bot) detectPlatform(url string) string {
@@ -254,54 +360,31 @@ func (b *Bot) sendQualityOptions(chatID int64, url, platform string) {
 	// Generate a short hash for the URL
 	urlID := b.cacheURL(url)
 
+	if platform != "youtube-playlist" {
+		b.sendVideoMenu(chatID, url, urlID)
+		return
+	}
+
+	messageText := "📋 *Playlist detected!*\n\nChoose what to download:"
+	// Downloads are in the user's default audio format and video container
 	keyboard := tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("🎬 Best Quality Video", fmt.Sprintf("v:best:%s", urlID)),
-			tgbotapi.NewInlineKeyboardButtonData("🎬 1080p", fmt.Sprintf("v:1080:%s", urlID)),
+			tgbotapi.NewInlineKeyboardButtonData("🎬 Single Video (Best)", fmt.Sprintf("v:best:%s", urlID)),
 		),
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("🎬 720p", fmt.Sprintf("v:720:%s", urlID)),
-			tgbotapi.NewInlineKeyboardButtonData("🎬 480p", fmt.Sprintf("v:480:%s", urlID)),
+			tgbotapi.NewInlineKeyboardButtonData("📋 First 5 Videos (Best)", fmt.Sprintf("p:5:best:%s", urlID)),
 		),
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("🎬 360p", fmt.Sprintf("v:360:%s", urlID)),
+			tgbotapi.NewInlineKeyboardButtonData("🎵 Single Audio (Best)", fmt.Sprintf("a:best:%s", urlID)),
 		),
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("🎵 MP3 Best", fmt.Sprintf("a:best:%s", urlID)),
-			tgbotapi.NewInlineKeyboardButtonData("🎵 MP3 320kbps", fmt.Sprintf("a:320:%s", urlID)),
+			tgbotapi.NewInlineKeyboardButtonData("📋 First 5 Audios (Best)", fmt.Sprintf("pa:5:best:%s", urlID)),
 		),
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("🎵 MP3 192kbps", fmt.Sprintf("a:192:%s", urlID)),
-			tgbotapi.NewInlineKeyboardButtonData("🎵 MP3 128kbps", fmt.Sprintf("a:128:%s", urlID)),
+			tgbotapi.NewInlineKeyboardButtonData("📋 View all items", fmt.Sprintf("list:%s", urlID)),
 		),
 	)
 
-	// Choose message based on platform
-	var messageText string
-	if platform == "youtube-playlist" {
-		messageText = "📋 *Playlist detected!*\n\nChoose what to download:"
-		// Update keyboard for playlist
-		keyboard = tgbotapi.NewInlineKeyboardMarkup(
-			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("🎬 Single Video (Best)", fmt.Sprintf("v:best:%s", urlID)),
-			),
-			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("📋 First 5 Videos (Best)", fmt.Sprintf("p:5:best:%s", urlID)),
-			),
-			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("🎵 Single Audio (MP3)", fmt.Sprintf("a:best:%s", urlID)),
-			),
-			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("📋 First 5 Audios (MP3)", fmt.Sprintf("pa:5:best:%s", urlID)),
-			),
-			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("📋 View all items", fmt.Sprintf("list:%s", urlID)),
-			),
-		)
-	} else {
-		messageText = "📥 *Choose quality:*\n\nSelect the format and quality you prefer:"
-	}
-
 	msg := tgbotapi.NewMessage(chatID, messageText)
 	msg.ParseMode = "Markdown"
 	msg.ReplyMarkup = keyboard
@@ -313,27 +396,60 @@ func (b *Bot) cacheURL(url string) string {
 	hash := md5.Sum([]byte(url))
 	urlID := hex.EncodeToString(hash[:])[:12] // Use first 12 chars
 
-	b.cacheMutex.Lock()
-	b.urlCache[urlID] = url
-	b.cacheMutex.Unlock()
+	// Stored so inline keyboards keep working across restarts
+	if err := b.store.Put(bucketURLs, urlID, url, b.config().URLCacheTTL); err != nil {
+		log.Printf("Failed to cache URL: %v", err)
+	}
 
 	return urlID
 }
 
 func (b *Bot) getURLFromCache(urlID string) string {
-	b.cacheMutex.RLock()
-	defer b.cacheMutex.RUnlock()
-	return b.urlCache[urlID]
+	var url string
+	if _, err := b.store.Get(bucketURLs, urlID, &url); err != nil {
+		log.Printf("URL cache lookup failed: %v", err)
+	}
+	return url
 }
 
 func (b *Bot) handleCallbackQuery(query *tgbotapi.CallbackQuery) {
 	parts := strings.Split(query.Data, ":")
 
-	// Handle short two-part callbacks (list/open) early
+	// Buttons without a URL: help, settings
+	switch parts[0] {
+	case "help":
+		callback := tgbotapi.NewCallback(query.ID, "Opening help...")
+		b.api.Request(callback)
+		b.sendHelpMessage(query.Message.Chat.ID)
+		return
+	case "settings":
+		callback := tgbotapi.NewCallback(query.ID, "Opening settings...")
+		b.api.Request(callback)
+		b.sendSettings(query.Message.Chat.ID, query.From.ID)
+		return
+	case "set":
+		b.handleSettingsCallback(query)
+		return
+	case "subs", "subl", "sub":
+		b.handleSubtitleCallback(query, parts)
+		return
+	case "tr":
+		b.handleTranscriptCallback(query, parts)
+		return
+	}
+
+	// Handle short two-part callbacks (list/open/thumb) early
 	if len(parts) == 2 {
+		if parts[0] == "list" || parts[0] == "open" {
+			if reply, ok := b.checkLimits(query.From.ID, false); !ok {
+				b.api.Request(tgbotapi.NewCallbackWithAlert(query.ID, reply))
+				return
+			}
+		}
 		if parts[0] == "list" {
 			urlID := parts[1]
-			b.presentPlaylistItems(query.Message.Chat.ID, urlID)
+			// Listing runs yt-dlp, so keep it off the update loop
+			go b.presentPlaylistItems(query.Message.Chat.ID, urlID)
 			callback := tgbotapi.NewCallback(query.ID, "Opening playlist items...")
 			b.api.Request(callback)
 			return
@@ -352,18 +468,21 @@ func (b *Bot) handleCallbackQuery(query *tgbotapi.CallbackQuery) {
 			b.sendQualityOptions(query.Message.Chat.ID, videoURL, "youtube")
 			return
 		}
-		if parts[0] == "help" {
-			// Show help message
-			callback := tgbotapi.NewCallback(query.ID, "Opening help...")
-			b.api.Request(callback)
-			b.sendHelpMessage(query.Message.Chat.ID)
+		if parts[0] == "cancel" {
+			b.cancelJob(query, parts[1])
 			return
 		}
-		if parts[0] == "settings" {
-			callback := tgbotapi.NewCallback(query.ID, "Opening settings...")
-			b.api.Request(callback)
-			msg := tgbotapi.NewMessage(query.Message.Chat.ID, "⚙️ Settings are minimal for now — send /help for instructions.")
-			b.api.Send(msg)
+		if parts[0] == "thumb" {
+			url, _, _ := splitClip(b.getURLFromCache(parts[1]))
+			if url == "" {
+				b.api.Request(tgbotapi.NewCallback(query.ID, "❌ Link expired. Please send the link again."))
+				return
+			}
+			if reply, ok := b.checkLimits(query.From.ID, true); !ok {
+				b.api.Request(tgbotapi.NewCallbackWithAlert(query.ID, reply))
+				return
+			}
+			b.enqueueJob(query, &Job{ChatID: query.Message.Chat.ID, UserID: query.From.ID, URL: url, Format: "thumbnail", Quality: "best"})
 			return
 		}
 	}
@@ -371,27 +490,47 @@ func (b *Bot) handleCallbackQuery(query *tgbotapi.CallbackQuery) {
 	if len(parts) < 3 {
 		return
 	}
-	formatType := parts[0] // "v" (video), "a" (audio), "p" (playlist video), "pa" (playlist audio)
+	formatType := parts[0] // "v" (video), "a" (audio), "p" (playlist video), "pa" (playlist audio), "ch" (chapters)
 
-	var quality, urlID string
+	var quality, urlID, outputFormat string
 	var playlistCount int
+	chapters := false
 
 	// Handle playlist format: "p:5:best:urlID" or "pa:5:best:urlID"
 	if formatType == "p" || formatType == "pa" {
 		if len(parts) != 4 {
 			return
 		}
-		playlistCount, _ = fmt.Sscanf(parts[1], "%d", &playlistCount)
+		fmt.Sscanf(parts[1], "%d", &playlistCount)
+		quality = parts[2]
+		urlID = parts[3]
+	} else if formatType == "ch" {
+		// Split by chapters: "ch:v:urlID" or "ch:a:urlID", in the user's default format
+		if len(parts) != 3 || (parts[1] != "v" && parts[1] != "a") {
+			return
+		}
+		formatType = parts[1]
+		chapters = true
+		quality = "best"
+		urlID = parts[2]
+	} else if len(parts) == 4 {
+		// A given container or audio format: "v:webm:best:urlID" or "a:m4a:best:urlID"
+		_, videoOK := lookupVideoContainer(parts[1])
+		_, audioOK := lookupAudioFormat(parts[1])
+		if (formatType == "v" && !videoOK) || (formatType == "a" && !audioOK) {
+			return
+		}
+		outputFormat = parts[1]
 		quality = parts[2]
 		urlID = parts[3]
 	} else {
-		// Regular format: "v:best:urlID" or "a:best:urlID"
+		// Regular format: "v:best:urlID" or "a:best:urlID", in the user's default format
 		quality = parts[1]
 		urlID = parts[2]
 	}
 
 	// Get URL from cache
-	url := b.getURLFromCache(urlID)
+	url, clip, _ := splitClip(b.getURLFromCache(urlID))
 	if url == "" {
 		callback := tgbotapi.NewCallback(query.ID, "❌ Link expired. Please send the link again.")
 		b.api.Request(callback)
@@ -408,145 +547,437 @@ func (b *Bot) handleCallbackQuery(query *tgbotapi.CallbackQuery) {
 		isPlaylist = true
 	}
 
-	// Answer callback query
-	callback := tgbotapi.NewCallback(query.ID, "Processing your request...")
-	b.api.Request(callback)
+	if reply, ok := b.checkLimits(query.From.ID, true); !ok {
+		b.api.Request(tgbotapi.NewCallbackWithAlert(query.ID, reply))
+		return
+	}
+
+	job := &Job{
+		ChatID:       query.Message.Chat.ID,
+		UserID:       query.From.ID,
+		URL:          url,
+		Format:       format,
+		Quality:      quality,
+		OutputFormat: outputFormat,
+		Playlist:     isPlaylist,
+		Count:        playlistCount,
+		Chapters:     chapters,
+	}
+	if !isPlaylist && !chapters {
+		job.Clip = clip
+	}
+	b.enqueueJob(query, job)
+}
+
+// enqueueJob answers the callback (if any), posts a status message and hands
+// the job to the worker pool.
+func (b *Bot) enqueueJob(query *tgbotapi.CallbackQuery, job *Job) {
+	if query != nil {
+		pending, active := b.jobs.Len()
+		if active < b.config().Workers && pending == 0 {
+			b.api.Request(tgbotapi.NewCallback(query.ID, "Processing your request..."))
+		} else {
+			b.api.Request(tgbotapi.NewCallback(query.ID, "Added to the download queue"))
+		}
+	}
+
+	statusMsg, _ := b.api.Send(tgbotapi.NewMessage(job.ChatID, "🕒 Queued..."))
+	job.StatusMsgID = statusMsg.MessageID
 
-	// Send processing message
+	position, err := b.jobs.Push(job)
+	if err != nil {
+		log.Printf("Rejected job for chat %d: %v", job.ChatID, err)
+		b.api.Send(tgbotapi.NewEditMessageText(job.ChatID, job.StatusMsgID,
+			"❌ The bot is very busy right now. Please try again in a few minutes."))
+		return
+	}
+
+	// Only mention the position if the job actually has to wait for a worker
+	if _, active := b.jobs.Len(); active >= b.config().Workers && b.jobs.Position(job.ID) > 0 {
+		b.setStatus(job, fmt.Sprintf("🕒 Queued — position %d. Your download will start soon.", position))
+	}
+}
+
+// setStatus edits the job's status message, keeping the cancel button.
+func (b *Bot) setStatus(job *Job, text string) {
+	edit := tgbotapi.NewEditMessageTextAndMarkup(job.ChatID, job.StatusMsgID, text, cancelKeyboard(job.ID))
+	b.api.Send(edit)
+}
+
+func cancelKeyboard(jobID int64) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✖ Cancel", fmt.Sprintf("cancel:%d", jobID)),
+		),
+	)
+}
+
+// cancelJob handles the "✖ Cancel" button of a status message.
+func (b *Bot) cancelJob(query *tgbotapi.CallbackQuery, jobID string) {
+	id, _ := strconv.ParseInt(jobID, 10, 64)
+	job := b.jobs.Find(id)
+	if job == nil {
+		b.api.Request(tgbotapi.NewCallback(query.ID, "This download has already finished."))
+		return
+	}
+	if job.UserID != query.From.ID {
+		b.api.Request(tgbotapi.NewCallback(query.ID, "Only the person who started this download can cancel it."))
+		return
+	}
+
+	if !b.stopJob(job) {
+		b.api.Request(tgbotapi.NewCallback(query.ID, "This download has already finished."))
+		return
+	}
+	b.api.Request(tgbotapi.NewCallback(query.ID, "Cancelling..."))
+	log.Printf("Job %d cancelled by user %d", id, query.From.ID)
+}
+
+// stopJob cancels a job and reports whether it was still waiting or running.
+func (b *Bot) stopJob(job *Job) bool {
+	removed, ok := b.jobs.Cancel(job.ID)
+	// A running job reports its own cancellation; a waiting one never reaches a worker
+	if removed {
+		b.api.Send(tgbotapi.NewEditMessageText(job.ChatID, job.StatusMsgID, "✖ Download cancelled."))
+		b.recordJob(job, context.Canceled)
+	}
+	return ok
+}
+
+// dropJob tells the user that a waiting job was thrown away on shutdown.
+func (b *Bot) dropJob(job *Job) {
+	b.api.Send(tgbotapi.NewEditMessageText(job.ChatID, job.StatusMsgID,
+		"✖ Download cancelled because the bot is shutting down. Please send the link again later."))
+	b.recordJob(job, context.Canceled)
+}
+
+// runJob is executed by a queue worker for every job.
+func (b *Bot) runJob(job *Job) {
+	chatID := job.ChatID
+
+	// Update status message now that the job has a worker
 	var processingText string
-	if isPlaylist {
-		processingText = fmt.Sprintf("⏳ Downloading %d items from playlist... This may take a few minutes.", playlistCount)
+	if job.Chapters {
+		processingText = "⏳ Downloading... The chapters follow once the whole file is in."
+	} else if job.Playlist {
+		processingText = fmt.Sprintf("⏳ Downloading %d items from playlist... This may take a few minutes.", job.Count)
 	} else {
 		processingText = "⏳ Downloading... This may take a few moments."
 	}
-	processingMsg := tgbotapi.NewMessage(query.Message.Chat.ID, processingText)
-	sentMsg, _ := b.api.Send(processingMsg)
+	b.setStatus(job, processingText)
 
 	// Download the media
-	if isPlaylist {
-		log.Printf("Starting playlist download: format=%s, quality=%s, count=%d, url=%s", format, quality, playlistCount, url)
-		b.downloadPlaylist(query.Message.Chat.ID, url, format, quality, playlistCount, sentMsg.MessageID)
+	if job.Playlist {
+		log.Printf("Starting playlist download: job=%d, format=%s, quality=%s, count=%d, url=%s", job.ID, job.Format, job.Quality, job.Count, job.URL)
+		err := b.downloadPlaylist(job)
+		b.recordJob(job, err)
 		return
 	}
 
-	log.Printf("Starting download: format=%s, quality=%s, url=%s", format, quality, url)
-	filePath, title, err := b.downloadMedia(url, format, quality)
-	if err != nil {
+	log.Printf("Starting download: job=%d, format=%s, quality=%s, clip=%v, chapters=%v, url=%s", job.ID, job.Format, job.Quality, job.Clip, job.Chapters, job.URL)
+	progress := b.progressReporter(job, "")
+	if job.Format == "video" && job.Quality == fitQuality {
+		// Say up front which quality will fit
+		if info, err := b.videoInfo(job.ctx, job.URL); err == nil {
+			header := planFit(job.Clip.apply(info), b.config().UploadLimit()).note()
+			b.setStatus(job, header+"\n\n"+processingText)
+			progress = b.progressReporter(job, header)
+		}
+	}
+	req := mediaRequest{URL: job.URL, Format: job.Format, Quality: job.Quality, OutputFormat: job.OutputFormat, Clip: job.Clip, Subtitles: job.Subtitles}
+	var err error
+	if job.Chapters {
+		err = b.deliverChapters(job.ctx, chatID, job.UserID, req, progress)
+	} else if job.Format == "thumbnail" {
+		err = b.deliverThumbnail(job.ctx, chatID, req, progress)
+	} else if job.Transcript {
+		err = b.deliverTranscript(job.ctx, chatID, req, job.Timestamps, progress)
+	} else if job.Subtitles != nil && !job.Subtitles.embeds() {
+		err = b.deliverSubtitles(job.ctx, chatID, job.UserID, req, progress)
+	} else {
+		err = b.deliverMedia(job.ctx, chatID, job.UserID, req, progress)
+	}
+	b.recordJob(job, err)
+	if errors.Is(err, context.Canceled) {
+		b.api.Send(tgbotapi.NewEditMessageText(chatID, job.StatusMsgID, "✖ Download cancelled."))
+		return
+	}
+	if err != nil && !errors.Is(err, errSendFailed) {
 		log.Printf("Download error: %v", err)
-		errorMsg := tgbotapi.NewMessage(query.Message.Chat.ID, fmt.Sprintf("❌ Error: %v", err))
+		errorMsg := tgbotapi.NewMessage(chatID, fmt.Sprintf("❌ Error: %v", err))
 		b.api.Send(errorMsg)
-		b.api.Request(tgbotapi.NewDeleteMessage(query.Message.Chat.ID, sentMsg.MessageID))
-		return
+	}
+
+	// Delete processing message
+	b.api.Request(tgbotapi.NewDeleteMessage(chatID, job.StatusMsgID))
+}
+
+// mediaRequest is a single video or audio to deliver.
+type mediaRequest struct {
+	URL     string
+	Format  string // "video" or "audio"
+	Quality string
+	// OutputFormat overrides the user's audio format or video container
+	OutputFormat string
+	// Album and Track (1-based) tag audio downloaded from a playlist
+	Album string
+	Track int
+	// Clip, when set, limits the download to a section of the video
+	Clip *clipRange
+	// Subtitles, when set, are soft-embedded in or burned into the video
+	Subtitles *subtitleRequest
+}
+
+// cacheQuality adds the album tags of an audio file to the quality part of
+// its file_id key, so a playlist track and a single download are kept apart.
+func (r mediaRequest) cacheQuality(quality string) string {
+	if r.Format != "audio" || (r.Album == "" && r.Track == 0) {
+		return quality
+	}
+	return fmt.Sprintf("%s-track%d-%s", quality, r.Track, r.Album)
+}
+
+// deliverMedia sends a single video or audio to the chat, following userID's
+// settings. Media that was delivered before in the same format, quality and
+// delivery options is re-sent by Telegram file_id; anything else is
+// downloaded, counted towards the user's daily usage and uploaded.
+// progress may be nil.
+func (b *Bot) deliverMedia(ctx context.Context, chatID, userID int64, req mediaRequest, progress func(Progress)) error {
+	url, format, quality, outputFormat := req.URL, req.Format, req.Quality, req.OutputFormat
+	info, err := b.videoInfo(ctx, url)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	title := info.Title
+	if title == "" {
+		title = info.ID
+	}
+	if req.Clip != nil {
+		clip := *req.Clip
+		if err := clip.fit(info.Duration); err != nil {
+			return err
+		}
+		req.Clip = &clip
+	}
+	// Sizes, fitting and usage are those of the clip
+	clipInfo := req.Clip.apply(info)
+	if req.Subtitles != nil && len(req.Subtitles.tracks(info)) == 0 {
+		return fmt.Errorf("this video has no %s subtitles", req.Subtitles.Lang)
+	}
+
+	settings := b.userSettings(userID).withDefaults()
+	switch {
+	case outputFormat == "":
+	case format == "video":
+		settings.VideoFormat = outputFormat
+	default:
+		settings.AudioFormat = outputFormat
+	}
+	if format == "video" && quality == fitQuality {
+		// Fitting is planned and re-encoded in MP4
+		settings.VideoFormat = "mp4"
+	}
+	if req.Subtitles != nil {
+		// Subtitles go into a plain MP4, where Telegram shows them; the
+		// max-compatibility conversion would drop a subtitle track
+		settings.VideoFormat = "mp4"
+	}
+	cacheKey := func(quality string) string {
+		return mediaKey(info.ID, format, req.cacheQuality(req.Subtitles.cacheQuality(req.Clip.cacheQuality(settings.cacheQuality(format, quality)))))
+	}
+	key := cacheKey(quality)
+	var fit *fitPlan
+	if format == "video" && quality == fitQuality {
+		plan := planFit(clipInfo, b.config().UploadLimit())
+		fit = &plan
+		quality = plan.Quality
+		key = cacheKey(plan.cacheQuality())
+	}
+	d := delivery{Format: format, Title: title, Settings: settings, Meta: newMediaMeta(clipInfo)}
+	if req.Clip != nil {
+		d.Title = title + "\n✂️ " + req.Clip.String()
+	}
+	if req.Subtitles != nil {
+		d.Title += "\n📝 " + req.Subtitles.label(info)
+	}
+	caption := d.Title
+	if fit != nil {
+		d.Title = caption + "\n" + fit.note()
+	}
+
+	if fileID, ok := b.cachedFileID(key); ok {
+		err := b.sendCachedFile(chatID, fileID, d)
+		if err == nil {
+			log.Printf("Delivered %s from cached file_id", key)
+			return nil
+		}
+		// The file_id may have been invalidated; fall back to a fresh download
+		log.Printf("Cached file_id for %s failed: %v", key, err)
+		b.forgetFileID(key)
+	}
+
+	filePath, err := b.downloadMedia(ctx, req, info, quality, settings.outputFormat(format), progress)
+	if err != nil {
+		return err
+	}
+	// yt-dlp writes subtitles to burn in next to the download
+	var subtitles string
+	if s := req.Subtitles; s != nil && s.Mode == "burn" {
+		subtitles = subtitlePath(filePath, s.Lang, s.fileExt())
+		defer os.Remove(subtitles)
+	}
+	if d.Meta.Thumb = b.prepareThumbnail(ctx, filePath, format); d.Meta.Thumb != "" {
+		defer os.Remove(d.Meta.Thumb)
 	}
 
 	log.Printf("Download successful: %s", filePath)
+	if stat, err := os.Stat(filePath); err == nil {
+		b.addUsage(userID, stat.Size(), clipInfo.Duration)
+	}
 
-	// Delete processing message
-	b.api.Request(tgbotapi.NewDeleteMessage(query.Message.Chat.ID, sentMsg.MessageID))
+	if container, _ := lookupVideoContainer(settings.VideoFormat); format == "video" && container.Compatible {
+		filePath, err = b.makeCompatible(ctx, filePath, progress)
+		if ctx.Err() != nil {
+			os.Remove(filePath)
+			return ctx.Err()
+		}
+		if err != nil {
+			// The download is most likely H.264 already; send it as is
+			log.Printf("Failed to convert %s: %v", filePath, err)
+		}
+	}
+
+	if subtitles != "" {
+		filePath, err = b.burnSubtitles(ctx, filePath, subtitles, progress)
+		if ctx.Err() != nil {
+			os.Remove(filePath)
+			return ctx.Err()
+		}
+		if err != nil {
+			os.Remove(filePath)
+			return fmt.Errorf("could not burn in the subtitles: %v", err)
+		}
+	}
+
+	if fit != nil {
+		filePath, err = b.reencodeToFit(ctx, filePath, fit, progress)
+		if ctx.Err() != nil {
+			os.Remove(filePath)
+			return ctx.Err()
+		}
+		if err != nil {
+			// Splitting below still gets the video delivered
+			log.Printf("Failed to fit %s: %v", filePath, err)
+		}
+		// A download over its estimate was re-encoded after all
+		d.Title = caption + "\n" + fit.note()
+	}
+
+	if format == "video" {
+		b.probeVideo(ctx, filePath, &d.Meta)
+	}
+
+	// Files over the endpoint's limit go out as several parts instead
+	if stat, err := os.Stat(filePath); err == nil && stat.Size() > b.config().UploadLimit() {
+		return b.deliverParts(ctx, chatID, filePath, d, progress)
+	}
+
+	if progress != nil {
+		progress(Progress{Stage: "uploading", Percent: -1})
+	}
 
 	// Send the file
 	log.Printf("Sending file to user... (title=%s)", title)
-	if err := b.sendFile(query.Message.Chat.ID, filePath, format, title); err != nil {
+	fileID, err := b.sendFile(chatID, filePath, d)
+	if err != nil {
 		log.Printf("Failed to send file: %v", err)
 		// Keep file so user can retry later or for debugging
-	} else {
-		// Clean up only after successful send
-		log.Printf("Cleaning up: %s", filePath)
-		os.Remove(filePath)
+		return fmt.Errorf("%w: %v", errSendFailed, err)
 	}
-}
 
-func (b *Bot) downloadPlaylist(chatID int64, url, format, quality string, count, processingMsgID int) {
-	ytdlp := b.getYtDlpPath()
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
-	defer cancel()
+	if fileID != "" {
+		b.rememberFileID(key, fileID)
+	}
 
-	// Download playlist info to get video URLs
-	timestamp := time.Now().UnixNano()
-	playlistInfoFile := filepath.Join(b.downloadPath, fmt.Sprintf("playlist_%d.txt", timestamp))
+	// Clean up only after successful send
+	log.Printf("Cleaning up: %s", filePath)
+	os.Remove(filePath)
+	return nil
+}
 
-	// Get first N video IDs from playlist
-	cmd := exec.CommandContext(ctx, ytdlp,
-		"--flat-playlist",
-		"--print", "url",
-		"--playlist-end", fmt.Sprintf("%d", count),
-		url)
+func (b *Bot) downloadPlaylist(job *Job) error {
+	chatID := job.ChatID
+	ctx, cancel := context.WithTimeout(job.ctx, 30*time.Minute)
+	defer cancel()
 
-	output, err := cmd.CombinedOutput()
+	// Get first N video URLs from playlist
+	entries, err := b.dl.PlaylistEntries(ctx, job.URL, job.Count)
+	if job.ctx.Err() != nil {
+		b.api.Send(tgbotapi.NewEditMessageText(chatID, job.StatusMsgID, "✖ Playlist download cancelled."))
+		return job.ctx.Err()
+	}
 	if err != nil {
-		log.Printf("Playlist fetch error: %v - %s", err, string(output))
+		log.Printf("Playlist fetch error: %v", err)
 		errorMsg := tgbotapi.NewMessage(chatID, "❌ Failed to fetch playlist. Please try again.")
 		b.api.Send(errorMsg)
-		b.api.Request(tgbotapi.NewDeleteMessage(chatID, processingMsgID))
-		return
+		b.api.Request(tgbotapi.NewDeleteMessage(chatID, job.StatusMsgID))
+		return err
 	}
 
-	videoURLs := strings.Split(strings.TrimSpace(string(output)), "\n")
-	log.Printf("Found %d videos in playlist", len(videoURLs))
+	log.Printf("Found %d videos in playlist", len(entries))
 
 	// Download each video
 	successCount := 0
-	for i, videoURL := range videoURLs {
-		if strings.TrimSpace(videoURL) == "" {
+	for i, entry := range entries {
+		if strings.TrimSpace(entry.URL) == "" {
 			continue
 		}
 
-		// Update status
-		statusMsg := tgbotapi.NewEditMessageText(chatID, processingMsgID,
-			fmt.Sprintf("⏳ Downloading item %d/%d from playlist...", i+1, len(videoURLs)))
-		b.api.Send(statusMsg)
+		// Stop once the user runs out of quota mid-playlist
+		if reply, exceeded := b.quotaExceeded(job.UserID); exceeded {
+			b.api.Send(tgbotapi.NewMessage(chatID, reply))
+			break
+		}
 
-		// Download single video
-		filePath, title, err := b.downloadMedia(videoURL, format, quality)
+		// Update status
+		header := fmt.Sprintf("📋 Item %d/%d: %s", i+1, len(entries), truncateString(entry.Title, 60))
+		b.setStatus(job, header+"\n\n⏳ Downloading...")
+
+		req := mediaRequest{
+			URL:          entry.URL,
+			Format:       job.Format,
+			Quality:      job.Quality,
+			OutputFormat: job.OutputFormat,
+			Album:        entry.Playlist,
+			Track:        i + 1,
+		}
+		err := b.deliverMedia(ctx, chatID, job.UserID, req, b.progressReporter(job, header))
+		if job.ctx.Err() != nil {
+			// Cancelled by the user: report what was delivered before stopping
+			b.api.Send(tgbotapi.NewEditMessageText(chatID, job.StatusMsgID,
+				fmt.Sprintf("✖ Playlist download cancelled. Delivered %d/%d items.", successCount, len(entries))))
+			return job.ctx.Err()
+		}
 		if err != nil {
-			log.Printf("Failed to download video %d: %v", i+1, err)
+			log.Printf("Failed to deliver playlist item %d: %v", i+1, err)
 			continue
 		}
-
-		// Send the file
-		if err := b.sendFile(chatID, filePath, format, title); err != nil {
-			log.Printf("Failed to send playlist item %d: %v", i+1, err)
-			// don't remove file; continue to next
-		} else {
-			os.Remove(filePath)
-			successCount++
-		}
 		successCount++
 	}
 
 	// Delete processing message and send completion message
-	b.api.Request(tgbotapi.NewDeleteMessage(chatID, processingMsgID))
+	b.api.Request(tgbotapi.NewDeleteMessage(chatID, job.StatusMsgID))
 	completionMsg := tgbotapi.NewMessage(chatID,
-		fmt.Sprintf("✅ Downloaded %d/%d items from playlist!", successCount, len(videoURLs)))
+		fmt.Sprintf("✅ Downloaded %d/%d items from playlist!", successCount, len(entries)))
 	b.api.Send(completionMsg)
 
-	os.Remove(playlistInfoFile)
-}
-
-// fetchPlaylistEntries fetches up to max entries (title and URL) from a playlist
-func (b *Bot) fetchPlaylistEntries(url string, max int) ([][2]string, error) {
-	ytdlp := b.getYtDlpPath()
-	args := []string{"--flat-playlist", "--no-warnings", "--print", "%(title)s||%(url)s", url}
-	cmd := exec.Command(ytdlp, args...)
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("playlist fetch failed: %v - %s", err, string(output))
-	}
-	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	entries := make([][2]string, 0, len(lines))
-	for i, line := range lines {
-		if max > 0 && i >= max {
-			break
-		}
-		parts := strings.SplitN(line, "||", 2)
-		if len(parts) != 2 {
-			continue
-		}
-		title := strings.TrimSpace(parts[0])
-		link := strings.TrimSpace(parts[1])
-		entries = append(entries, [2]string{title, link})
+	if successCount == 0 && len(entries) > 0 {
+		return fmt.Errorf("none of %d playlist items could be delivered", len(entries))
 	}
-	return entries, nil
+	return nil
 }
 
 // presentPlaylistItems sends a message with playlist items and buttons to open each item
@@ -559,7 +990,9 @@ func (b *Bot) presentPlaylistItems(chatID int64, playlistURLID string) {
 	}
 
 	// Fetch up to 25 items
-	entries, err := b.fetchPlaylistEntries(url, 25)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	entries, err := b.dl.PlaylistEntries(ctx, url, 25)
 	if err != nil || len(entries) == 0 {
 		msg := tgbotapi.NewMessage(chatID, "❌ Failed to fetch playlist items or playlist is empty.")
 		b.api.Send(msg)
@@ -569,10 +1002,8 @@ func (b *Bot) presentPlaylistItems(chatID int64, playlistURLID string) {
 	// Build keyboard
 	rows := [][]tgbotapi.InlineKeyboardButton{}
 	for i, e := range entries {
-		title := e[0]
-		link := e[1]
-		id := b.cacheURL(link)
-		display := fmt.Sprintf("%02d. %s", i+1, truncateString(title, 50))
+		id := b.cacheURL(e.URL)
+		display := fmt.Sprintf("%02d. %s", i+1, truncateString(e.Title, 50))
 		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(display, fmt.Sprintf("open:%s", id))))
 	}
 	// Add a back button
@@ -592,110 +1023,79 @@ func truncateString(s string, n int) string {
 	return s[:n-1] + "…"
 }
 
-func (b *Bot) downloadMedia(url, format, quality string) (string, string, error) {
-	// Use timestamp with nanoseconds for uniqueness fallback
-	timestamp := time.Now().UnixNano()
-	var outputFile string
-	ytdlp := b.getYtDlpPath()
-
-	// Try to get title and id for nicer filenames (avoid warnings in output)
-	title := ""
-	id := fmt.Sprintf("%d", timestamp)
-	if out, err := exec.Command(ytdlp, "--no-warnings", "--get-title", url).Output(); err == nil {
-		// take the last non-empty line to avoid warnings appearing before/after
-		lines := strings.Split(strings.TrimSpace(string(out)), "\n")
-		for i := len(lines) - 1; i >= 0; i-- {
-			l := strings.TrimSpace(lines[i])
-			if l != "" {
-				title = l
-				break
-			}
-		}
-	}
-	if out, err := exec.Command(ytdlp, "--no-warnings", "--get-id", url).Output(); err == nil {
-		id = strings.TrimSpace(string(out))
-	}
-	if title == "" {
-		title = fmt.Sprintf("media_%d", timestamp)
-	}
-	// Sanitize title for filesystem
-	safeTitle := sanitizeFilename(title)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+// downloadMedia fetches req into the download directory at the given
+// quality. outputFormat is the audio format or video container to produce.
+// The video's thumbnail is written next to it, see thumbnailPath.
+func (b *Bot) downloadMedia(ctx context.Context, req mediaRequest, info *VideoInfo, quality, outputFormat string, progress func(Progress)) (string, error) {
+	format := req.Format
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
 	defer cancel()
 
-	// Common args for better compatibility
-	commonArgs := []string{
-		"--no-playlist",
-		"--no-warnings",
-		"--user-agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
-	}
-
-	// Add cookies if file exists (for Facebook/Instagram)
-	cookiesFile := "cookies.txt"
-	if _, err := os.Stat(cookiesFile); err == nil {
-		commonArgs = append(commonArgs, "--cookies", cookiesFile)
-	}
+	// Sanitize title for filesystem
+	safeTitle := sanitizeFilename(info.Title)
+	id := info.ID
 
-	var cmd *exec.Cmd
-	var ext string
+	audio, _ := lookupAudioFormat(outputFormat)
+	ext := audio.Ext
+	var container string
 	if format == "video" {
-		ext = "mp4"
-		outputFile = filepath.Join(b.downloadPath, fmt.Sprintf("%s - %s.%s", safeTitle, id, ext))
-		formatStr := b.getVideoFormat(quality)
-		args := append([]string{"-f", formatStr, "--merge-output-format", ext, "-o", outputFile}, commonArgs...)
-		args = append(args, url)
-		cmd = exec.CommandContext(ctx, ytdlp, args...)
-	} else {
-		ext = "mp3"
-		outputFile = filepath.Join(b.downloadPath, fmt.Sprintf("%s - %s.%s", safeTitle, id, ext))
-		bitrateStr := b.getAudioBitrate(quality)
-		args := append([]string{"-x", "--audio-format", "mp3", "--audio-quality", bitrateStr, "-o", outputFile}, commonArgs...)
-		args = append(args, url)
-		cmd = exec.CommandContext(ctx, ytdlp, args...)
+		c, _ := lookupVideoContainer(outputFormat)
+		ext, container = c.Ext, c.Name
 	}
-
-	log.Printf("Running: %s (output: %s)", cmd.String(), outputFile)
-
-	output, err := cmd.CombinedOutput()
-	log.Printf("yt-dlp finished with error: %v", err)
-	log.Printf("yt-dlp output: %s", string(output))
-
+	name := fmt.Sprintf("%s - %s", safeTitle, id)
+	if req.Clip != nil {
+		// Clips of the same video must not overwrite each other
+		name += fmt.Sprintf(" (%g-%g)", req.Clip.Start, req.Clip.End)
+	}
+	outputFile := filepath.Join(b.downloadPath, name+"."+ext)
+
+	err := b.dl.Fetch(ctx, FetchRequest{
+		URL:       req.URL,
+		Format:    format,
+		Quality:   quality,
+		Audio:     outputFormat,
+		Container: container,
+		Album:     req.Album,
+		Track:     req.Track,
+		Thumbnail: true,
+		Clip:      req.Clip,
+		Subtitles: req.Subtitles,
+		Output:    outputFile,
+		Info:      info,
+		Progress:  progress,
+	})
 	if err != nil {
-		// Extract meaningful error from output
-		outputStr := string(output)
-		errorMsg := "Download failed"
-
-		if strings.Contains(outputStr, "ERROR:") {
-			// Find the error line
-			lines := strings.Split(outputStr, "\n")
-			for _, line := range lines {
-				if strings.Contains(line, "ERROR:") {
-					// Clean up the error message
-					errorMsg = strings.TrimSpace(strings.TrimPrefix(line, "ERROR:"))
-					// Simplify common errors
-					if strings.Contains(errorMsg, "SSL") || strings.Contains(errorMsg, "handshake") || strings.Contains(errorMsg, "timed out") {
-						errorMsg = "Connection timeout. Facebook/Instagram may be blocking downloads. Try a YouTube link instead."
-					} else if strings.Contains(errorMsg, "Unable to download webpage") {
-						errorMsg = "Cannot access this video. It may be private or region-locked."
-					} else if strings.Contains(errorMsg, "Video unavailable") {
-						errorMsg = "Video is unavailable or has been removed."
-					}
-					break
-				}
-			}
+		if ctx.Err() != nil {
+			// Cancelled or timed out: don't leave half-downloaded files behind
+			removePartialFiles(outputFile)
 		}
-
-		return "", "", fmt.Errorf("%s", errorMsg)
+		return "", err
 	}
 
 	// Check if file exists
 	if _, err := os.Stat(outputFile); os.IsNotExist(err) {
-		return "", "", fmt.Errorf("output file not found: %s", outputFile)
+		return "", fmt.Errorf("output file not found: %s", outputFile)
 	}
 
 	log.Printf("Successfully downloaded to: %s", outputFile)
-	return outputFile, title, nil
+	return outputFile, nil
+}
+
+// removePartialFiles deletes the output file of an aborted download together
+// with yt-dlp's intermediate files (.part, .ytdl, per-format streams).
+func removePartialFiles(outputFile string) {
+	dir := filepath.Dir(outputFile)
+	prefix := strings.TrimSuffix(filepath.Base(outputFile), filepath.Ext(outputFile)) + "."
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), prefix) {
+			log.Printf("Removing partial file: %s", e.Name())
+			os.Remove(filepath.Join(dir, e.Name()))
+		}
+	}
 }
 
 // sanitizeFilename removes or replaces characters not safe for filenames
@@ -719,78 +1119,35 @@ func sanitizeFilename(name string) string {
 	return name
 }
 
-func (b *Bot) getVideoFormat(quality string) string {
-	switch quality {
-	case "best":
-		return "bestvideo[ext=mp4]+bestaudio[ext=m4a]/best[ext=mp4]/best"
-	case "1080":
-		return "bestvideo[height<=1080][ext=mp4]+bestaudio[ext=m4a]/best[height<=1080][ext=mp4]/best"
-	case "720":
-		return "bestvideo[height<=720][ext=mp4]+bestaudio[ext=m4a]/best[height<=720][ext=mp4]/best"
-	case "480":
-		return "bestvideo[height<=480][ext=mp4]+bestaudio[ext=m4a]/best[height<=480][ext=mp4]/best"
-	case "360":
-		return "bestvideo[height<=360][ext=mp4]+bestaudio[ext=m4a]/best[height<=360][ext=mp4]/best"
-	default:
-		return "best[ext=mp4]/best"
-	}
-}
-
-func (b *Bot) getAudioBitrate(quality string) string {
-	switch quality {
-	case "best":
-		return "0"
-	case "320":
-		return "320K"
-	case "192":
-		return "192K"
-	case "128":
-		return "128K"
-	default:
-		return "0"
-	}
-}
-
-func (b *Bot) sendFile(chatID int64, filePath, format, title string) error {
+// sendFile uploads a downloaded file and returns the file_id Telegram assigned to it.
+func (b *Bot) sendFile(chatID int64, filePath string, d delivery) (string, error) {
 	fileInfo, err := os.Stat(filePath)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "❌ Error reading file")
 		b.api.Send(msg)
-		return err
+		return "", err
 	}
 
-	// Telegram file size limit is 50MB
-	const maxSize = 50 * 1024 * 1024
-	if fileInfo.Size() > maxSize {
-		msg := tgbotapi.NewMessage(chatID, "❌ File is too large (>50MB). Try a lower quality.")
+	if limit := b.config().UploadLimit(); fileInfo.Size() > limit {
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("❌ File is too large (>%s). Try a lower quality.", formatBytes(limit)))
 		b.api.Send(msg)
-		return fmt.Errorf("file too large")
+		return "", fmt.Errorf("file too large")
+	}
+
+	file, err := b.uploadFile(filePath)
+	if err != nil {
+		return "", err
 	}
 
 	// Try sending with retries for transient network issues
 	var lastErr error
 	maxAttempts := 3
 	for attempt := 1; attempt <= maxAttempts; attempt++ {
-		if format == "video" {
-			video := tgbotapi.NewVideo(chatID, tgbotapi.FilePath(filePath))
-			if title != "" {
-				video.Caption = fmt.Sprintf("✅ %s", title)
-			} else {
-				video.Caption = "✅ Here's your video!"
-			}
-			_, lastErr = b.api.Send(video)
-		} else {
-			audio := tgbotapi.NewAudio(chatID, tgbotapi.FilePath(filePath))
-			if title != "" {
-				audio.Caption = fmt.Sprintf("✅ %s", title)
-			} else {
-				audio.Caption = "✅ Here's your audio!"
-			}
-			_, lastErr = b.api.Send(audio)
-		}
+		var sent tgbotapi.Message
+		sent, lastErr = b.sendMedia(mediaMessage(chatID, file, d), d.Meta)
 
 		if lastErr == nil {
-			return nil
+			return sentFileID(sent), nil
 		}
 
 		// Detect likely transient network errors by inspecting error text
@@ -808,5 +1165,113 @@ func (b *Bot) sendFile(chatID int64, filePath, format, title string) error {
 	// If we're here, send failed
 	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("❌ Error sending file after %d attempts: %v", maxAttempts, lastErr))
 	b.api.Send(msg)
-	return lastErr
+	return "", lastErr
+}
+
+// uploadFile returns how a downloaded file is handed to the Bot API. A local
+// server shares our filesystem and reads the file itself; otherwise the bytes
+// are uploaded with the request.
+func (b *Bot) uploadFile(path string) (tgbotapi.RequestFileData, error) {
+	if !b.config().localServer() || !b.config().BotAPILocalFiles {
+		return tgbotapi.FilePath(path), nil
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	return tgbotapi.FileURL("file://" + filepath.ToSlash(abs)), nil
+}
+
+// sendCachedFile re-sends media Telegram already stores, without uploading it again.
+func (b *Bot) sendCachedFile(chatID int64, fileID string, d delivery) error {
+	// Telegram keeps the metadata with the file; a thumbnail can't be re-sent
+	d.Meta.Thumb = ""
+	_, err := b.api.Send(mediaMessage(chatID, tgbotapi.FileID(fileID), d))
+	return err
+}
+
+// mediaMessage builds the video, audio, voice or document message for a
+// file, captioned in the user's style.
+func mediaMessage(chatID int64, file tgbotapi.RequestFileData, d delivery) tgbotapi.Chattable {
+	format, settings, meta := d.Format, d.Settings, d.Meta
+	caption := settings.caption(d.Title, format)
+	var thumb tgbotapi.RequestFileData
+	if meta.Thumb != "" {
+		thumb = tgbotapi.FilePath(meta.Thumb)
+	}
+	// A voice note was asked for explicitly, so it wins over "send as document"
+	if audio, _ := lookupAudioFormat(settings.AudioFormat); format == "audio" && audio.Voice {
+		voice := tgbotapi.NewVoice(chatID, file)
+		voice.Caption = caption
+		voice.Duration = meta.Duration
+		return voice
+	}
+	// Telegram only plays MP4 inline, other containers arrive as files anyway
+	container, _ := lookupVideoContainer(settings.VideoFormat)
+	if settings.AsDocument || (format == "video" && container.Ext != "mp4") {
+		doc := tgbotapi.NewDocument(chatID, file)
+		doc.Caption = caption
+		doc.Thumb = thumb
+		return doc
+	}
+	if format == "video" {
+		video := tgbotapi.NewVideo(chatID, file)
+		video.Caption = caption
+		video.Duration = meta.Duration
+		video.Thumb = thumb
+		// MP4s are written with the index up front, so they play while loading
+		video.SupportsStreaming = true
+		return video
+	}
+	audio := tgbotapi.NewAudio(chatID, file)
+	audio.Caption = caption
+	audio.Performer = meta.Performer
+	audio.Title = meta.Title
+	audio.Duration = meta.Duration
+	audio.Thumb = thumb
+	return audio
+}
+
+// sendMedia sends a media message. Videos with known dimensions go out
+// through a raw sendVideo call, as tgbotapi's VideoConfig has no width and
+// height; without them clients show a wrongly shaped preview.
+func (b *Bot) sendMedia(c tgbotapi.Chattable, meta mediaMeta) (tgbotapi.Message, error) {
+	video, ok := c.(tgbotapi.VideoConfig)
+	if !ok || meta.Width == 0 || meta.Height == 0 {
+		return b.api.Send(c)
+	}
+	params := tgbotapi.Params{}
+	params.AddNonZero64("chat_id", video.ChatID)
+	params.AddNonEmpty("caption", video.Caption)
+	params.AddNonZero("duration", video.Duration)
+	params.AddNonZero("width", meta.Width)
+	params.AddNonZero("height", meta.Height)
+	params.AddBool("supports_streaming", video.SupportsStreaming)
+	files := []tgbotapi.RequestFile{{Name: "video", Data: video.File}}
+	if video.Thumb != nil {
+		files = append(files, tgbotapi.RequestFile{Name: "thumb", Data: video.Thumb})
+	}
+
+	resp, err := b.api.UploadFiles("sendVideo", params, files)
+	if err != nil {
+		return tgbotapi.Message{}, err
+	}
+	var msg tgbotapi.Message
+	err = json.Unmarshal(resp.Result, &msg)
+	return msg, err
+}
+
+// sentFileID returns the file_id of the media attached to a sent message.
+func sentFileID(msg tgbotapi.Message) string {
+	switch {
+	case msg.Video != nil:
+		return msg.Video.FileID
+	case msg.Audio != nil:
+		return msg.Audio.FileID
+	case msg.Document != nil:
+		return msg.Document.FileID
+	case msg.Voice != nil:
+		return msg.Voice.FileID
+	}
+	return ""
 }
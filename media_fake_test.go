@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// fakeMedia is a MediaProcessor that pretends to run ffmpeg. Split writes
+// one small file per limit-sized chunk of the input.
+type fakeMedia struct {
+	mu        sync.Mutex
+	splits    []string
+	reencodes []string
+	converted []string
+	thumbs    []string
+	chapters  [][]Chapter
+	burns     []string
+	probe     VideoProbe
+	err       error
+}
+
+func (f *fakeMedia) Available() bool { return true }
+
+func (f *fakeMedia) Split(ctx context.Context, path string, limit int64) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.splits = append(f.splits, path)
+	if f.err != nil {
+		return nil, f.err
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	n := int((stat.Size() + limit - 1) / limit)
+	ext := filepath.Ext(path)
+	var parts []string
+	for i := 0; i < n; i++ {
+		part := fmt.Sprintf("%s - part%02d%s", strings.TrimSuffix(path, ext), i, ext)
+		if err := os.WriteFile(part, []byte("fake part"), 0644); err != nil {
+			return parts, err
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+// Reencode writes a small "(fitted).mp4" next to path.
+func (f *fakeMedia) Reencode(ctx context.Context, path string, limit int64) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reencodes = append(f.reencodes, path)
+	if f.err != nil {
+		return "", f.err
+	}
+	out := strings.TrimSuffix(path, filepath.Ext(path)) + " (fitted).mp4"
+	return out, os.WriteFile(out, []byte("fake fitted"), 0644)
+}
+
+// MakeCompatible writes a small "(compatible).mp4" next to path.
+func (f *fakeMedia) MakeCompatible(ctx context.Context, path string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.converted = append(f.converted, path)
+	if f.err != nil {
+		return "", f.err
+	}
+	out := strings.TrimSuffix(path, filepath.Ext(path)) + " (compatible).mp4"
+	return out, os.WriteFile(out, []byte("fake compatible"), 0644)
+}
+
+// Thumbnail writes a small "(thumb).jpg" next to path.
+func (f *fakeMedia) Thumbnail(ctx context.Context, path string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.thumbs = append(f.thumbs, path)
+	if f.err != nil {
+		return "", f.err
+	}
+	out := strings.TrimSuffix(path, filepath.Ext(path)) + " (thumb).jpg"
+	return out, os.WriteFile(out, []byte("fake thumb"), 0644)
+}
+
+// Probe reports the scripted probe result.
+func (f *fakeMedia) Probe(ctx context.Context, path string) (VideoProbe, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.probe, f.err
+}
+
+// SplitChapters writes one small " - chapterNN" file per chapter next to path.
+func (f *fakeMedia) SplitChapters(ctx context.Context, path string, chapters []Chapter) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.chapters = append(f.chapters, chapters)
+	if f.err != nil {
+		return nil, f.err
+	}
+	ext := filepath.Ext(path)
+	var files []string
+	for i := range chapters {
+		out := fmt.Sprintf("%s - chapter%02d%s", strings.TrimSuffix(path, ext), i+1, ext)
+		if err := os.WriteFile(out, []byte("fake chapter"), 0644); err != nil {
+			return files, err
+		}
+		files = append(files, out)
+	}
+	return files, nil
+}
+
+// BurnSubtitles writes a small "(subtitled).mp4" next to path.
+func (f *fakeMedia) BurnSubtitles(ctx context.Context, path, subtitles string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.burns = append(f.burns, subtitles)
+	if f.err != nil {
+		return "", f.err
+	}
+	out := strings.TrimSuffix(path, filepath.Ext(path)) + " (subtitled).mp4"
+	return out, os.WriteFile(out, []byte("fake subtitled"), 0644)
+}
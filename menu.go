@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maxVideoOptions caps the number of resolution buttons in the quality menu.
+const maxVideoOptions = 6
+
+// qualityOption is one button of the quality menu.
+type qualityOption struct {
+	Label   string
+	Quality string // callback quality token, e.g. "best", "720", "320"
+	Height  int    // video resolution, 0 for audio
+	Size    int64  // estimated size in bytes, 0 when unknown
+}
+
+// tooLarge reports whether the option's estimate exceeds limit.
+func (o qualityOption) tooLarge(limit int64) bool {
+	return o.Size > limit
+}
+
+// sendVideoMenu posts the quality menu for a single video. The menu is built
+// from the formats the video actually offers, so it first shows a placeholder
+// while the metadata is fetched. url may end in a clip range, see splitClip.
+func (b *Bot) sendVideoMenu(chatID int64, url, urlID string) {
+	url, clip, _ := splitClip(url)
+	placeholder, err := b.api.Send(tgbotapi.NewMessage(chatID, "🔎 Looking up available formats..."))
+	if err != nil {
+		log.Printf("Failed to send menu placeholder: %v", err)
+		return
+	}
+
+	// The metadata lookup runs yt-dlp, so keep it off the update loop
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+
+		var text string
+		var keyboard tgbotapi.InlineKeyboardMarkup
+		info, err := b.videoInfo(ctx, url)
+		if err != nil || len(info.Formats) == 0 {
+			// Fall back to the fixed menu; the download reports any real problem
+			log.Printf("Using the static quality menu for %s (info error: %v)", url, err)
+			text = "📥 *Choose quality:*\n\nSelect the format and quality you prefer:"
+			keyboard = staticQualityKeyboard(urlID)
+		} else {
+			text, keyboard = qualityMenu(clip.apply(info), urlID, b.config().UploadLimit())
+		}
+		if clip != nil {
+			text = fmt.Sprintf("✂️ Clip %s\n\n%s", clip, text)
+		}
+
+		edit := tgbotapi.NewEditMessageTextAndMarkup(chatID, placeholder.MessageID, text, keyboard)
+		edit.ParseMode = "Markdown"
+		b.api.Send(edit)
+	}()
+}
+
+// qualityMenu builds the menu text and keyboard for a video. Options whose
+// estimated size exceeds limit are left out and listed in the text instead.
+func qualityMenu(info *VideoInfo, urlID string, limit int64) (string, tgbotapi.InlineKeyboardMarkup) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "📥 *%s*\n", tgbotapi.EscapeText(tgbotapi.ModeMarkdown, info.Title))
+	var details []string
+	if info.Uploader != "" {
+		details = append(details, "👤 "+tgbotapi.EscapeText(tgbotapi.ModeMarkdown, info.Uploader))
+	}
+	if info.Duration > 0 {
+		details = append(details, "⏱ "+formatDuration(info.Duration))
+	}
+	if len(details) > 0 {
+		sb.WriteString(strings.Join(details, " • "))
+		sb.WriteString("\n")
+	}
+	sb.WriteString("\n*Choose quality:*")
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var hidden []string
+	addRows := func(prefix string, options []qualityOption) (skipped int) {
+		var row []tgbotapi.InlineKeyboardButton
+		for _, o := range options {
+			label := o.Label
+			if o.Size > 0 {
+				label += " · ~" + formatBytes(o.Size)
+			}
+			if o.tooLarge(limit) {
+				hidden = append(hidden, label)
+				skipped++
+				continue
+			}
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("%s:%s:%s", prefix, o.Quality, urlID)))
+			if len(row) == 2 {
+				rows = append(rows, row)
+				row = nil
+			}
+		}
+		if len(row) > 0 {
+			rows = append(rows, row)
+		}
+		return skipped
+	}
+	if addRows("v", videoOptions(info)) > 0 {
+		// Offer the best video that fits instead of the hidden ones
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("📦 Fit to Telegram (≤%s)", formatBytes(limit)), fmt.Sprintf("v:%s:%s", fitQuality, urlID)),
+		))
+	}
+	addRows("v", containerOptions(info))
+	addRows("a", audioOptions(info))
+	addRows("a", audioFormatOptions(info))
+
+	if len(hidden) > 0 {
+		fmt.Fprintf(&sb, "\n\n⚠️ Too large to send (over %s): %s", formatBytes(limit), strings.Join(hidden, ", "))
+	}
+	if len(rows) == 0 {
+		sb.WriteString("\n\n❌ Every available quality is too large to deliver.")
+	}
+
+	// Options that don't depend on the size limit
+	if len(subtitleLanguages(info)) > 0 {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("📝 Subtitles", "subs:"+urlID),
+		), tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🧾 Transcript", "tr:p:"+urlID),
+			tgbotapi.NewInlineKeyboardButtonData("🧾 With timestamps", "tr:t:"+urlID),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🖼 Thumbnail", "thumb:"+urlID),
+	))
+	if len(info.Chapters) > 1 {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("📑 %d chapters as video", len(info.Chapters)), "ch:v:"+urlID),
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("📑 %d chapters as audio", len(info.Chapters)), "ch:a:"+urlID),
+		))
+	}
+	return sb.String(), tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// videoOptions lists "Best" plus one option per resolution the video offers.
+func videoOptions(info *VideoInfo) []qualityOption {
+	seen := make(map[int]bool)
+	var heights []int
+	for _, f := range info.Formats {
+		if f.HasVideo() && f.Height > 0 && !seen[f.Height] {
+			seen[f.Height] = true
+			heights = append(heights, f.Height)
+		}
+	}
+	if len(heights) == 0 {
+		return nil
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(heights)))
+	if len(heights) > maxVideoOptions {
+		heights = heights[:maxVideoOptions]
+	}
+
+	options := []qualityOption{{
+		Label:   fmt.Sprintf("🎬 Best (%dp)", heights[0]),
+		Quality: "best",
+		Height:  heights[0],
+		Size:    estimateVideoSize(info, 0, videoContainers[0]),
+	}}
+	// The top resolution is what "Best" already delivers
+	for _, h := range heights[1:] {
+		options = append(options, qualityOption{
+			Label:   fmt.Sprintf("🎬 %dp", h),
+			Quality: fmt.Sprintf("%d", h),
+			Height:  h,
+			Size:    estimateVideoSize(info, h, videoContainers[0]),
+		})
+	}
+	return options
+}
+
+// audioBitrates are the MP3 options; "best" is VBR, estimated at ~245 kbps.
+// Their buttons name the format ("a:mp3:320:<id>") so they don't depend on
+// the user's audio format setting.
+var audioBitrates = []struct {
+	quality string
+	label   string
+	kbps    float64
+}{
+	{"best", "🎵 MP3 Best", 245},
+	{"320", "🎵 MP3 320kbps", 320},
+	{"192", "🎵 MP3 192kbps", 192},
+	{"128", "🎵 MP3 128kbps", 128},
+}
+
+func audioOptions(info *VideoInfo) []qualityOption {
+	var options []qualityOption
+	for _, a := range audioBitrates {
+		options = append(options, qualityOption{
+			Label:   a.label,
+			Quality: "mp3:" + a.quality,
+			Size:    int64(info.Duration * a.kbps * 1000 / 8),
+		})
+	}
+	return options
+}
+
+// audioFormatOptions offers the other audio formats at their best quality.
+// M4A and Opus are usually just the original stream, so their real size is known.
+func audioFormatOptions(info *VideoInfo) []qualityOption {
+	var options []qualityOption
+	for _, f := range audioFormats[1:] {
+		size := int64(info.Duration * f.Kbps * 1000 / 8)
+		var source *Format
+		switch f.Codec {
+		case "m4a":
+			source = bestFormat(info.Formats, func(s Format) bool { return s.HasAudio() && !s.HasVideo() && s.Ext == "m4a" })
+		case "opus":
+			source = bestFormat(info.Formats, func(s Format) bool { return s.HasAudio() && !s.HasVideo() && strings.HasPrefix(s.ACodec, "opus") })
+		}
+		if source != nil {
+			size = streamSize(info, *source)
+		}
+		label := "🎵 " + f.Label
+		if f.Voice {
+			label = "🎙 " + f.Label
+		}
+		options = append(options, qualityOption{Label: label, Quality: f.Name + ":best", Size: size})
+	}
+	return options
+}
+
+// estimateVideoSize predicts the size of a video download capped at
+// maxHeight (0 = no cap), mirroring the container's selector: best video +
+// best audio stream, else the best single file with both.
+func estimateVideoSize(info *VideoInfo, maxHeight int, c videoContainer) int64 {
+	fits := func(f Format) bool { return maxHeight == 0 || f.Height <= maxHeight }
+
+	video := bestFormat(info.Formats, func(f Format) bool {
+		return f.HasVideo() && !f.HasAudio() && c.matches(f, c.VideoFilter) && fits(f)
+	})
+	audio := bestFormat(info.Formats, func(f Format) bool { return f.HasAudio() && !f.HasVideo() && c.matches(f, c.AudioFilter) })
+	if video != nil && audio != nil {
+		return streamSize(info, *video) + streamSize(info, *audio)
+	}
+	if muxed := bestFormat(info.Formats, func(f Format) bool { return f.HasVideo() && f.HasAudio() && c.matches(f, c.MuxedFilter) && fits(f) }); muxed != nil {
+		return streamSize(info, *muxed)
+	}
+	return 0
+}
+
+// containerOptions offers the other video containers at their best quality.
+func containerOptions(info *VideoInfo) []qualityOption {
+	var options []qualityOption
+	for _, c := range videoContainers[1:] {
+		label := "🎞 " + c.Label
+		if c.Compatible {
+			label = "📱 " + c.Label
+		}
+		options = append(options, qualityOption{Label: label, Quality: c.Name + ":best", Size: estimateVideoSize(info, 0, c)})
+	}
+	return options
+}
+
+// bestFormat returns the highest resolution / bitrate format matching keep.
+func bestFormat(formats []Format, keep func(Format) bool) *Format {
+	var best *Format
+	for i := range formats {
+		f := &formats[i]
+		if !keep(*f) {
+			continue
+		}
+		if best == nil || f.Height > best.Height || (f.Height == best.Height && f.TBR > best.TBR) {
+			best = f
+		}
+	}
+	return best
+}
+
+// streamSize is the known size of a format, or an estimate from its bitrate.
+func streamSize(info *VideoInfo, f Format) int64 {
+	if size := f.Size(); size > 0 {
+		return size
+	}
+	return int64(f.TBR * 1000 / 8 * info.Duration)
+}
+
+// formatDuration renders seconds as m:ss or h:mm:ss.
+func formatDuration(seconds float64) string {
+	d := time.Duration(seconds) * time.Second
+	h := int(d.Hours())
+	m := int(d.Minutes()) % 60
+	s := int(d.Seconds()) % 60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
+
+// staticQualityKeyboard is the fixed menu used when no format list is available.
+func staticQualityKeyboard(urlID string) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🎬 Best Quality Video", fmt.Sprintf("v:best:%s", urlID)),
+			tgbotapi.NewInlineKeyboardButtonData("🎬 1080p", fmt.Sprintf("v:1080:%s", urlID)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🎬 720p", fmt.Sprintf("v:720:%s", urlID)),
+			tgbotapi.NewInlineKeyboardButtonData("🎬 480p", fmt.Sprintf("v:480:%s", urlID)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🎬 360p", fmt.Sprintf("v:360:%s", urlID)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🎵 MP3 Best", fmt.Sprintf("a:mp3:best:%s", urlID)),
+			tgbotapi.NewInlineKeyboardButtonData("🎵 MP3 320kbps", fmt.Sprintf("a:mp3:320:%s", urlID)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🎵 MP3 192kbps", fmt.Sprintf("a:mp3:192:%s", urlID)),
+			tgbotapi.NewInlineKeyboardButtonData("🎵 MP3 128kbps", fmt.Sprintf("a:mp3:128:%s", urlID)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🎵 M4A", fmt.Sprintf("a:m4a:best:%s", urlID)),
+			tgbotapi.NewInlineKeyboardButtonData("🎵 Opus", fmt.Sprintf("a:opus:best:%s", urlID)),
+			tgbotapi.NewInlineKeyboardButtonData("🎵 FLAC", fmt.Sprintf("a:flac:best:%s", urlID)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🎵 WAV", fmt.Sprintf("a:wav:best:%s", urlID)),
+			tgbotapi.NewInlineKeyboardButtonData("🎵 OGG", fmt.Sprintf("a:ogg:best:%s", urlID)),
+			tgbotapi.NewInlineKeyboardButtonData("🎙 Voice", fmt.Sprintf("a:voice:best:%s", urlID)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🖼 Thumbnail", "thumb:"+urlID),
+		),
+	)
+}
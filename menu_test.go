@@ -0,0 +1,162 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func menuButtons(keyboard tgbotapi.InlineKeyboardMarkup) []string {
+	var data []string
+	for _, row := range keyboard.InlineKeyboard {
+		for _, button := range row {
+			data = append(data, *button.CallbackData)
+		}
+	}
+	return data
+}
+
+func TestQualityMenuFromFormats(t *testing.T) {
+	info := loadInfo(t)
+
+	text, keyboard := qualityMenu(info, "abc", 1<<40)
+	want := []string{"v:best:abc", "v:720:abc", "v:480:abc", "v:360:abc", "v:webm:best:abc", "v:mkv:best:abc", "v:compat:best:abc", "a:mp3:best:abc", "a:mp3:320:abc", "a:mp3:192:abc", "a:mp3:128:abc",
+		"a:m4a:best:abc", "a:opus:best:abc", "a:ogg:best:abc", "a:flac:best:abc", "a:wav:best:abc", "a:voice:best:abc", "thumb:abc", "ch:v:abc", "ch:a:abc"}
+	if got := menuButtons(keyboard); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("buttons = %v, want %v", got, want)
+	}
+	if !strings.Contains(text, "Never Gonna Give You Up") || !strings.Contains(text, "Rick Astley") || !strings.Contains(text, "3:32") {
+		t.Errorf("menu text lacks video details: %q", text)
+	}
+	if label := keyboard.InlineKeyboard[0][0].Text; label != "🎬 Best (1080p) · ~57.5 MB" {
+		t.Errorf("best label = %q", label)
+	}
+}
+
+func TestQualityMenuHidesOversizedOptions(t *testing.T) {
+	info := loadInfo(t)
+
+	text, keyboard := qualityMenu(info, "abc", publicUploadLimit)
+	buttons := strings.Join(menuButtons(keyboard), " ")
+	if strings.HasPrefix(buttons, "v:best:") || strings.Contains(buttons, " v:best:") {
+		t.Errorf("1080p best should be hidden above the limit: %s", buttons)
+	}
+	if !strings.Contains(buttons, "v:720:abc") || !strings.Contains(buttons, "a:mp3:best:abc") || !strings.Contains(buttons, "v:fit:abc") {
+		t.Errorf("smaller options missing: %s", buttons)
+	}
+	if !strings.Contains(text, "Too large to send") || !strings.Contains(text, "Best (1080p)") {
+		t.Errorf("oversized option not explained: %q", text)
+	}
+}
+
+func TestAudioFormatOptionSizes(t *testing.T) {
+	info := loadInfo(t)
+
+	sizes := make(map[string]int64)
+	for _, o := range audioFormatOptions(info) {
+		sizes[o.Quality] = o.Size
+	}
+	// M4A and Opus keep the original stream, so their size is the stream's
+	if sizes["m4a:best"] != 3433514 || sizes["opus:best"] != 3437753 {
+		t.Errorf("sizes = %v, want the m4a and opus stream sizes", sizes)
+	}
+	if sizes["wav:best"] <= sizes["flac:best"] {
+		t.Errorf("wav should be estimated larger than flac: %v", sizes)
+	}
+}
+
+func TestContainerOptionSizes(t *testing.T) {
+	info := loadInfo(t)
+
+	sizes := make(map[string]int64)
+	for _, o := range containerOptions(info) {
+		sizes[o.Quality] = o.Size
+	}
+	// WebM pairs the VP9 stream with Opus; compatibility mode H.264 with AAC
+	if want := int64(40632614 + 3437753); sizes["webm:best"] != want {
+		t.Errorf("webm size = %d, want %d", sizes["webm:best"], want)
+	}
+	if want := int64(56906400 + 3433514); sizes["compat:best"] != want {
+		t.Errorf("compat size = %d, want %d", sizes["compat:best"], want)
+	}
+}
+
+func TestPlanFit(t *testing.T) {
+	info := loadInfo(t)
+
+	if plan := planFit(info, publicUploadLimit); plan.Quality != "720" || plan.Reencode {
+		t.Errorf("plan for 50MB = %+v, want 720p without re-encoding", plan)
+	}
+	if plan := planFit(info, 1<<40); plan.Quality != "best" {
+		t.Errorf("plan without a limit = %+v, want best", plan)
+	}
+	plan := planFit(info, 1024)
+	if plan.Quality != "360" || !plan.Reencode {
+		t.Errorf("plan for 1KB = %+v, want re-encoded 360p", plan)
+	}
+	if !strings.Contains(plan.note(), "360p, re-encoded") {
+		t.Errorf("note = %q", plan.note())
+	}
+}
+
+func TestVideoSelectorHeights(t *testing.T) {
+	mp4, _ := lookupVideoContainer("mp4")
+	if got := mp4.selector("best"); got != "bestvideo[ext=mp4]+bestaudio[ext=m4a]/best[ext=mp4]/best" {
+		t.Errorf("selector(best) = %q", got)
+	}
+	if got := mp4.selector("1440"); !strings.Contains(got, "bestvideo[height<=1440][ext=mp4]") {
+		t.Errorf("selector(1440) = %q", got)
+	}
+	if got := mp4.selector("bogus"); got != "best[ext=mp4]/best" {
+		t.Errorf("selector(bogus) = %q", got)
+	}
+	compat, _ := lookupVideoContainer("compat")
+	if got := compat.selector("720"); !strings.HasPrefix(got, "bestvideo[height<=720][vcodec^=avc1]+bestaudio[acodec^=mp4a]/") {
+		t.Errorf("compat selector(720) = %q", got)
+	}
+	mkv, _ := lookupVideoContainer("mkv")
+	if got := mkv.selector("best"); got != "bestvideo+bestaudio/best" {
+		t.Errorf("mkv selector(best) = %q", got)
+	}
+}
+
+func TestQualityMenuOffersSubtitles(t *testing.T) {
+	info := loadInfo(t)
+	_, keyboard := qualityMenu(info, "abc", 1<<40)
+	if strings.Contains(strings.Join(menuButtons(keyboard), " "), "subs:abc") {
+		t.Errorf("subtitles offered for a video without any")
+	}
+
+	info.AutomaticCaptions = map[string][]SubtitleTrack{"en": {{Ext: "vtt"}}, "de": {{Ext: "vtt"}}}
+	if langs := subtitleLanguages(info); len(langs) != 1 || langs[0].Lang != "en" || !langs[0].Auto {
+		t.Errorf("languages = %+v, want the English captions only", langs)
+	}
+	_, keyboard = qualityMenu(info, "abc", 1<<40)
+	if !strings.Contains(strings.Join(menuButtons(keyboard), " "), "subs:abc") {
+		t.Errorf("subtitles button missing")
+	}
+}
+
+func TestBestThumbnail(t *testing.T) {
+	info := loadInfo(t)
+	if best := bestThumbnail(info); best == nil || best.Width != 1920 || !strings.HasSuffix(best.URL, "maxresdefault.webp") {
+		t.Errorf("best thumbnail = %+v", best)
+	}
+	if best := bestThumbnail(&VideoInfo{}); best != nil {
+		t.Errorf("best thumbnail of a video without any = %+v", best)
+	}
+}
+
+func TestQualityMenuExtrasIgnoreLimit(t *testing.T) {
+	info := loadInfo(t)
+
+	text, keyboard := qualityMenu(info, "abc", 1)
+	if !strings.Contains(text, "Too large to send") {
+		t.Errorf("menu text = %q", text)
+	}
+	// Fitting, the thumbnail and the chapters are still on offer
+	if got := strings.Join(menuButtons(keyboard), " "); got != "v:fit:abc thumb:abc ch:v:abc ch:a:abc" {
+		t.Errorf("buttons = %s", got)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Messenger is the part of the Telegram Bot API the bot talks to.
+// *tgbotapi.BotAPI satisfies it; tests point a BotAPI at a local stand-in server.
+type Messenger interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
+	// UploadFiles calls a method with raw parameters, for the few that
+	// tgbotapi's configs don't cover.
+	UploadFiles(endpoint string, params tgbotapi.Params, files []tgbotapi.RequestFile) (*tgbotapi.APIResponse, error)
+}
+
+// handleUpdates dispatches updates until the channel is closed.
+func (b *Bot) handleUpdates(updates tgbotapi.UpdatesChannel) {
+	for update := range updates {
+		b.handleUpdate(update)
+	}
+}
+
+func (b *Bot) handleUpdate(update tgbotapi.Update) {
+	if !b.allowUpdate(&update) {
+		return
+	}
+
+	if update.CallbackQuery != nil {
+		b.handleCallbackQuery(update.CallbackQuery)
+		return
+	}
+
+	if update.Message == nil {
+		return
+	}
+
+	if update.Message.IsCommand() {
+		b.handleCommand(update.Message)
+	} else {
+		b.handleMessage(update.Message)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// mediaMeta is what Telegram shows about a file besides its caption.
+type mediaMeta struct {
+	Performer string
+	Title     string
+	Duration  int // seconds
+	Width     int // video dimensions, 0 when unknown
+	Height    int
+	Thumb     string // path of a JPEG thumbnail, "" for none
+}
+
+// delivery is how a file is presented in the chat.
+type delivery struct {
+	Format   string // "video" or "audio"
+	Title    string // rendered into the caption in the user's style
+	Settings UserSettings
+	Meta     mediaMeta
+}
+
+// newMediaMeta describes a download for Telegram's player: the track and
+// artist when YouTube knows them (music videos), else the title and channel.
+func newMediaMeta(info *VideoInfo) mediaMeta {
+	m := mediaMeta{Performer: info.Artist, Title: info.Track, Duration: int(info.Duration)}
+	if m.Performer == "" {
+		m.Performer = info.Uploader
+	}
+	if m.Performer == "" {
+		m.Performer = info.Channel
+	}
+	if m.Title == "" {
+		m.Title = info.Title
+	}
+	return m
+}
+
+// thumbnailPath is where the thumbnail of a download is written when
+// FetchRequest.Thumbnail is set.
+func thumbnailPath(filePath string) string {
+	return strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ".jpg"
+}
+
+// prepareThumbnail turns the thumbnail written next to a download into one
+// Telegram accepts. Videos without one get a frame of the video instead.
+// It returns "" when there is none.
+func (b *Bot) prepareThumbnail(ctx context.Context, filePath, format string) string {
+	src := thumbnailPath(filePath)
+	if _, err := os.Stat(src); err == nil {
+		defer os.Remove(src)
+	} else if format == "video" {
+		src = filePath
+	} else {
+		return ""
+	}
+	if !b.media.Available() {
+		return ""
+	}
+	thumb, err := b.media.Thumbnail(ctx, src)
+	if err != nil {
+		log.Printf("Failed to prepare thumbnail for %s: %v", filePath, err)
+		return ""
+	}
+	return thumb
+}
+
+// probeVideo fills in the dimensions and exact duration of the video that
+// is about to be sent, which may differ from the metadata after re-encoding.
+func (b *Bot) probeVideo(ctx context.Context, filePath string, meta *mediaMeta) {
+	if !b.media.Available() {
+		return
+	}
+	p, err := b.media.Probe(ctx, filePath)
+	if err != nil {
+		log.Printf("Failed to probe %s: %v", filePath, err)
+		return
+	}
+	meta.Width, meta.Height = p.Width, p.Height
+	if p.Duration > 0 {
+		meta.Duration = int(p.Duration + 0.5)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Progress is a snapshot of a running download reported by the Downloader.
+type Progress struct {
+	Stage      string  // "downloading" or a post-processing step such as "merging"
+	Percent    float64 // 0-100, negative when unknown
+	Downloaded int64
+	Total      int64   // bytes, 0 when unknown
+	Speed      float64 // bytes per second, 0 when unknown
+	ETA        time.Duration
+}
+
+// ytDlpProgressTemplate makes yt-dlp print one machine readable line per
+// progress update: downloaded|total|total_estimate|speed|eta.
+const ytDlpProgressTemplate = "download:[progress] %(progress.downloaded_bytes)s|%(progress.total_bytes)s|%(progress.total_bytes_estimate)s|%(progress.speed)s|%(progress.eta)s"
+
+// Post-processor prefixes printed by yt-dlp and the stage they represent.
+var ytDlpStages = []struct{ prefix, stage string }{
+	{"[Merger]", "merging"},
+	{"[ExtractAudio]", "extracting audio"},
+	{"[VideoConvertor]", "converting"},
+	{"[VideoRemuxer]", "converting"},
+	{"[EmbedThumbnail]", "adding metadata"},
+	{"[Metadata]", "adding metadata"},
+	{"[FixupM3u8]", "fixing up"},
+	{"[FixupM4a]", "fixing up"},
+	{"[FixupStretched]", "fixing up"},
+}
+
+// parseProgressLine turns a line of yt-dlp output into a Progress update.
+func parseProgressLine(line string) (Progress, bool) {
+	line = strings.TrimSpace(line)
+	if rest := strings.TrimPrefix(line, "[progress] "); rest != line {
+		fields := strings.Split(rest, "|")
+		if len(fields) != 5 {
+			return Progress{}, false
+		}
+		p := Progress{Stage: "downloading", Percent: -1}
+		p.Downloaded = int64(parseNumber(fields[0]))
+		p.Total = int64(parseNumber(fields[1]))
+		if p.Total == 0 {
+			p.Total = int64(parseNumber(fields[2]))
+		}
+		p.Speed = parseNumber(fields[3])
+		p.ETA = time.Duration(parseNumber(fields[4])) * time.Second
+		if p.Total > 0 {
+			p.Percent = float64(p.Downloaded) * 100 / float64(p.Total)
+		}
+		return p, true
+	}
+	for _, s := range ytDlpStages {
+		if strings.HasPrefix(line, s.prefix) {
+			return Progress{Stage: s.stage, Percent: -1}, true
+		}
+	}
+	return Progress{}, false
+}
+
+// parseNumber parses yt-dlp template values, which are "NA" when unknown.
+func parseNumber(s string) float64 {
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0
+	}
+	return f
+}
+
+// progressEditInterval keeps status edits well below Telegram's rate limits.
+const progressEditInterval = 3 * time.Second
+
+// progressReporter returns a callback that edits the job's status message with
+// download progress. header is shown above the progress (e.g. the playlist
+// item being downloaded). Edits are throttled and skipped when nothing changed.
+func (b *Bot) progressReporter(job *Job, header string) func(Progress) {
+	var (
+		mu        sync.Mutex
+		lastText  string
+		lastStage string
+		lastEdit  time.Time
+	)
+	return func(p Progress) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if p.Stage == lastStage && time.Since(lastEdit) < progressEditInterval {
+			return
+		}
+		text := formatProgress(header, p)
+		if text == lastText {
+			return
+		}
+		lastText, lastStage, lastEdit = text, p.Stage, time.Now()
+		b.setStatus(job, text)
+	}
+}
+
+func formatProgress(header string, p Progress) string {
+	var sb strings.Builder
+	if header != "" {
+		sb.WriteString(header)
+		sb.WriteString("\n\n")
+	}
+	if p.Stage != "downloading" {
+		fmt.Fprintf(&sb, "⚙️ %s...", strings.ToUpper(p.Stage[:1])+p.Stage[1:])
+		return sb.String()
+	}
+
+	if p.Percent < 0 {
+		fmt.Fprintf(&sb, "⏳ Downloading... %s", formatBytes(p.Downloaded))
+	} else {
+		fmt.Fprintf(&sb, "⏳ Downloading... %.0f%%\n%s", p.Percent, progressBar(p.Percent, 10))
+	}
+	var details []string
+	if p.Total > 0 {
+		details = append(details, fmt.Sprintf("📦 %s / %s", formatBytes(p.Downloaded), formatBytes(p.Total)))
+	}
+	if p.Speed > 0 {
+		details = append(details, fmt.Sprintf("🚀 %s/s", formatBytes(int64(p.Speed))))
+	}
+	if p.ETA > 0 {
+		details = append(details, fmt.Sprintf("⏱ ETA %s", p.ETA))
+	}
+	if len(details) > 0 {
+		sb.WriteString("\n")
+		sb.WriteString(strings.Join(details, " • "))
+	}
+	return sb.String()
+}
+
+func progressBar(percent float64, width int) string {
+	filled := int(percent / 100 * float64(width))
+	if filled > width {
+		filled = width
+	}
+	if filled < 0 {
+		filled = 0
+	}
+	return strings.Repeat("▓", filled) + strings.Repeat("░", width-filled)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
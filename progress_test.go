@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseProgressLine(t *testing.T) {
+	tests := []struct {
+		line string
+		ok   bool
+		want Progress
+	}{
+		{
+			line: "[progress] 5242880|10485760|NA|1048576.5|5",
+			ok:   true,
+			want: Progress{Stage: "downloading", Percent: 50, Downloaded: 5242880, Total: 10485760, Speed: 1048576.5, ETA: 5 * time.Second},
+		},
+		{
+			// Only an estimate of the total size is known
+			line: "[progress] 1024|NA|4096|NA|NA",
+			ok:   true,
+			want: Progress{Stage: "downloading", Percent: 25, Downloaded: 1024, Total: 4096},
+		},
+		{
+			line: "[progress] 1024|NA|NA|NA|NA",
+			ok:   true,
+			want: Progress{Stage: "downloading", Percent: -1, Downloaded: 1024},
+		},
+		{
+			line: `[Merger] Merging formats into "downloads/x.mp4"`,
+			ok:   true,
+			want: Progress{Stage: "merging", Percent: -1},
+		},
+		{
+			line: "[ExtractAudio] Destination: downloads/x.mp3",
+			ok:   true,
+			want: Progress{Stage: "extracting audio", Percent: -1},
+		},
+		{line: "[youtube] dQw4w9WgXcQ: Downloading webpage"},
+		{line: "[progress] garbage"},
+	}
+	for _, tt := range tests {
+		got, ok := parseProgressLine(tt.line)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseProgressLine(%q) = %+v, %v; want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestFormatProgress(t *testing.T) {
+	got := formatProgress("📋 Item 1/3: Song", Progress{
+		Stage: "downloading", Percent: 42, Downloaded: 42 << 20, Total: 100 << 20,
+		Speed: 2 << 20, ETA: 29 * time.Second,
+	})
+	for _, want := range []string{"📋 Item 1/3: Song", "42%", "▓▓▓▓░░░░░░", "42.0 MB / 100.0 MB", "2.0 MB/s", "ETA 29s"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("formatProgress output %q is missing %q", got, want)
+		}
+	}
+
+	if got := formatProgress("", Progress{Stage: "merging", Percent: -1}); got != "⚙️ Merging..." {
+		t.Errorf("stage message = %q", got)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+var errQueueFull = errors.New("download queue is full")
+
+// Job is a single download request waiting for, or being handled by, a worker.
+type Job struct {
+	ID      int64
+	ChatID  int64
+	UserID  int64
+	URL     string
+	Format  string // "video" or "audio"
+	Quality string
+	// OutputFormat overrides the user's audio format or video container
+	// setting, see audioFormats and videoContainers
+	OutputFormat string
+	Playlist     bool
+	Count        int // number of playlist items to fetch when Playlist is set
+	// Clip, when set, limits a single video or audio to a time range
+	Clip *clipRange
+	// Chapters sends a single video or audio as one file per chapter
+	Chapters bool
+	// Subtitles, when set, delivers a subtitle track as a file (Format
+	// "subtitles") or inside the video
+	Subtitles *subtitleRequest
+	// Transcript sends the captions of a video as text (Format "transcript"),
+	// starting each paragraph with its time when Timestamps is set
+	Transcript bool
+	Timestamps bool
+
+	// StatusMsgID is the "queued/downloading" message the worker edits and removes.
+	StatusMsgID int
+
+	Enqueued time.Time
+	Started  time.Time
+
+	// ctx is cancelled when the user presses "Cancel"; all work for the job derives from it.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// JobQueue is a FIFO of download jobs served by a fixed pool of workers.
+type JobQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []*Job
+	active  map[int64]*Job
+	limit   int
+	nextID  int64
+	closed  bool
+	wg      sync.WaitGroup
+	// dropped, if set, is called for every waiting job Close throws away
+	dropped func(*Job)
+}
+
+// NewJobQueue creates a queue that holds at most limit waiting jobs.
+func NewJobQueue(limit int) *JobQueue {
+	q := &JobQueue{
+		active: make(map[int64]*Job),
+		limit:  limit,
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Start launches n workers that call handle for every job taken off the queue.
+func (q *JobQueue) Start(n int, handle func(*Job)) {
+	for i := 0; i < n; i++ {
+		q.wg.Add(1)
+		go q.worker(i+1, handle)
+	}
+	log.Printf("Started %d download workers", n)
+}
+
+func (q *JobQueue) worker(id int, handle func(*Job)) {
+	defer q.wg.Done()
+	for {
+		job, ok := q.next()
+		if !ok {
+			return
+		}
+		log.Printf("Worker %d picked job %d (waited %s)", id, job.ID, job.Started.Sub(job.Enqueued).Round(time.Second))
+		func() {
+			// A panicking job must not take the worker down with it
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("Worker %d: job %d panicked: %v", id, job.ID, r)
+				}
+			}()
+			handle(job)
+		}()
+		q.finish(job)
+	}
+}
+
+// Push assigns the job an ID and appends it to the queue. It returns the
+// job's 1-based position among waiting jobs.
+func (q *JobQueue) Push(job *Job) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed || len(q.pending) >= q.limit {
+		return 0, errQueueFull
+	}
+	q.nextID++
+	job.ID = q.nextID
+	job.Enqueued = time.Now()
+	job.ctx, job.cancel = context.WithCancel(context.Background())
+	q.pending = append(q.pending, job)
+	q.cond.Signal()
+	return len(q.pending), nil
+}
+
+// next blocks until a job is available. It returns false once the queue is closed.
+func (q *JobQueue) next() (*Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.pending) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return nil, false
+	}
+	job := q.pending[0]
+	q.pending[0] = nil
+	q.pending = q.pending[1:]
+	job.Started = time.Now()
+	q.active[job.ID] = job
+	return job, true
+}
+
+func (q *JobQueue) finish(job *Job) {
+	q.mu.Lock()
+	delete(q.active, job.ID)
+	q.mu.Unlock()
+	job.cancel()
+}
+
+// Find returns a waiting or running job, or nil.
+func (q *JobQueue) Find(id int64) *Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if job, ok := q.active[id]; ok {
+		return job
+	}
+	for _, job := range q.pending {
+		if job.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// Cancel cancels a job's context. A waiting job is also removed from the
+// queue, in which case removed is true and no worker will ever see it.
+func (q *JobQueue) Cancel(id int64) (removed, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if job, found := q.active[id]; found {
+		job.cancel()
+		return false, true
+	}
+	for i, job := range q.pending {
+		if job.ID == id {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			job.cancel()
+			return true, true
+		}
+	}
+	return false, false
+}
+
+// UserJobs counts the waiting and running jobs of a user.
+func (q *JobQueue) UserJobs(userID int64) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for _, job := range q.active {
+		if job.UserID == userID {
+			n++
+		}
+	}
+	for _, job := range q.pending {
+		if job.UserID == userID {
+			n++
+		}
+	}
+	return n
+}
+
+// Position returns the 1-based position of a waiting job, or 0 if it is
+// running, finished or unknown.
+func (q *JobQueue) Position(id int64) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, job := range q.pending {
+		if job.ID == id {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Snapshot returns copies of the running and the waiting jobs, in queue order.
+func (q *JobQueue) Snapshot() (active, pending []Job) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, job := range q.active {
+		active = append(active, *job)
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].ID < active[j].ID })
+	for _, job := range q.pending {
+		pending = append(pending, *job)
+	}
+	return active, pending
+}
+
+// Len reports how many jobs are waiting and how many are running.
+func (q *JobQueue) Len() (pending, active int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending), len(q.active)
+}
+
+// Close cancels the running and waiting jobs, drops the waiting ones and
+// waits for the workers to finish.
+func (q *JobQueue) Close() {
+	q.mu.Lock()
+	q.closed = true
+	for _, job := range q.active {
+		job.cancel()
+	}
+	pending := q.pending
+	for _, job := range pending {
+		job.cancel()
+	}
+	q.pending = nil
+	q.cond.Broadcast()
+	q.mu.Unlock()
+
+	if q.dropped != nil {
+		for _, job := range pending {
+			q.dropped(job)
+		}
+	}
+	q.wg.Wait()
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds or a second has passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestJobQueueFIFO(t *testing.T) {
+	q := NewJobQueue(10)
+	for i := 0; i < 5; i++ {
+		if pos, err := q.Push(&Job{ChatID: int64(i)}); err != nil || pos != i+1 {
+			t.Fatalf("Push %d = %d, %v", i, pos, err)
+		}
+	}
+
+	var mu sync.Mutex
+	var order []int64
+	q.Start(1, func(job *Job) {
+		mu.Lock()
+		order = append(order, job.ChatID)
+		mu.Unlock()
+	})
+	waitFor(t, "all jobs", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(order) == 5
+	})
+	q.Close()
+
+	for i, chatID := range order {
+		if chatID != int64(i) {
+			t.Fatalf("jobs ran in order %v, want 0..4", order)
+		}
+	}
+}
+
+func TestJobQueueWorkerLimit(t *testing.T) {
+	q := NewJobQueue(10)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	running, peak := 0, 0
+	q.Start(2, func(job *Job) {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		<-release
+		mu.Lock()
+		running--
+		mu.Unlock()
+	})
+
+	jobs := make([]*Job, 4)
+	for i := range jobs {
+		jobs[i] = &Job{}
+		q.Push(jobs[i])
+	}
+	waitFor(t, "two running jobs", func() bool {
+		_, active := q.Len()
+		return active == 2
+	})
+	if pending, _ := q.Len(); pending != 2 {
+		t.Errorf("%d jobs waiting, want 2", pending)
+	}
+	if pos := q.Position(jobs[3].ID); pos != 2 {
+		t.Errorf("last job at position %d, want 2", pos)
+	}
+
+	close(release)
+	waitFor(t, "the queue to drain", func() bool {
+		pending, active := q.Len()
+		return pending == 0 && active == 0
+	})
+	q.Close()
+	if peak != 2 {
+		t.Errorf("%d jobs ran at once, want 2", peak)
+	}
+}
+
+func TestJobQueueCancelPending(t *testing.T) {
+	q := NewJobQueue(10)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var handled []int64
+	q.Start(1, func(job *Job) {
+		mu.Lock()
+		handled = append(handled, job.ID)
+		mu.Unlock()
+		<-release
+	})
+
+	running, waiting := &Job{}, &Job{}
+	q.Push(running)
+	q.Push(waiting)
+	waitFor(t, "the first job to start", func() bool {
+		_, active := q.Len()
+		return active == 1
+	})
+
+	removed, ok := q.Cancel(waiting.ID)
+	if !removed || !ok {
+		t.Fatalf("Cancel(waiting) = %v, %v, want removed", removed, ok)
+	}
+	if waiting.ctx.Err() == nil {
+		t.Error("cancelled job's context is still live")
+	}
+	if q.Find(waiting.ID) != nil {
+		t.Error("cancelled job can still be found")
+	}
+	if removed, ok := q.Cancel(running.ID); removed || !ok {
+		t.Errorf("Cancel(running) = %v, %v, want cancelled but not removed", removed, ok)
+	}
+	if running.ctx.Err() == nil {
+		t.Error("running job's context is still live")
+	}
+
+	close(release)
+	q.Close()
+	if len(handled) != 1 || handled[0] != running.ID {
+		t.Errorf("handled jobs %v, want only %d", handled, running.ID)
+	}
+}
+
+func TestJobQueueClose(t *testing.T) {
+	q := NewJobQueue(10)
+	var dropped []*Job
+	q.dropped = func(job *Job) { dropped = append(dropped, job) }
+	started := make(chan struct{})
+	var finished atomic.Bool
+	q.Start(1, func(job *Job) {
+		close(started)
+		// Only returns once Close cancels it
+		<-job.ctx.Done()
+		time.Sleep(20 * time.Millisecond)
+		finished.Store(true)
+	})
+
+	running, waiting := &Job{}, &Job{}
+	q.Push(running)
+	<-started
+	q.Push(waiting)
+
+	closed := make(chan struct{})
+	go func() {
+		q.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close did not cancel the running job")
+	}
+	if !finished.Load() {
+		t.Error("Close returned before the worker finished its job")
+	}
+	if waiting.ctx.Err() == nil {
+		t.Error("waiting job was not cancelled")
+	}
+	if len(dropped) != 1 || dropped[0] != waiting {
+		t.Errorf("dropped %v, want only the waiting job", dropped)
+	}
+	if pending, active := q.Len(); pending != 0 || active != 0 {
+		t.Errorf("Len after Close = %d, %d", pending, active)
+	}
+	if _, err := q.Push(&Job{}); !errors.Is(err, errQueueFull) {
+		t.Errorf("Push after Close = %v, want errQueueFull", err)
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// UserSettings are a user's stored preferences.
+type UserSettings struct {
+	Format      string `json:"format,omitempty"`       // default format, "video" or "audio"
+	Quality     string `json:"quality,omitempty"`      // default quality for that format
+	AudioFormat string `json:"audio_format,omitempty"` // audio codec/container, e.g. "mp3"
+	VideoFormat string `json:"video_format,omitempty"` // video container, e.g. "mp4"
+	AsDocument  bool   `json:"as_document,omitempty"`  // send files as documents instead of media
+	Caption     string `json:"caption,omitempty"`      // caption style, see captionStyles
+	// AutoDownload skips the quality menu for single videos and downloads
+	// with the defaults right away.
+	AutoDownload bool `json:"auto_download,omitempty"`
+}
+
+// settingChoice is one value of a setting with its label.
+type settingChoice struct {
+	value, label string
+}
+
+var (
+	formatChoices = []settingChoice{{"video", "🎬 Video"}, {"audio", "🎵 Audio"}}
+
+	videoQualityChoices = []settingChoice{{"best", "Best"}, {"1080", "1080p"}, {"720", "720p"}, {"480", "480p"}, {"360", "360p"}}
+	audioQualityChoices = []settingChoice{{"best", "Best"}, {"320", "320kbps"}, {"192", "192kbps"}, {"128", "128kbps"}}
+
+	audioFormatChoices = audioFormatSettingChoices()
+	videoFormatChoices = videoFormatSettingChoices()
+
+	captionStyles = []settingChoice{{"full", "✅ Title"}, {"title", "Title only"}, {"none", "No caption"}}
+)
+
+func audioFormatSettingChoices() []settingChoice {
+	choices := make([]settingChoice, 0, len(audioFormats))
+	for _, f := range audioFormats {
+		choices = append(choices, settingChoice{f.Name, f.Label})
+	}
+	return choices
+}
+
+func videoFormatSettingChoices() []settingChoice {
+	choices := make([]settingChoice, 0, len(videoContainers))
+	for _, c := range videoContainers {
+		choices = append(choices, settingChoice{c.Name, c.Label})
+	}
+	return choices
+}
+
+// withDefaults fills unset fields with the bot's defaults.
+func (s UserSettings) withDefaults() UserSettings {
+	if s.Format == "" {
+		s.Format = "video"
+	}
+	if s.Quality == "" {
+		s.Quality = "best"
+	}
+	if s.AudioFormat == "" {
+		s.AudioFormat = "mp3"
+	}
+	if s.VideoFormat == "" {
+		s.VideoFormat = "mp4"
+	}
+	if s.Caption == "" {
+		s.Caption = "full"
+	}
+	return s
+}
+
+// cacheQuality extends quality with the settings that change the uploaded
+// file, so a cached file_id is only reused for an identical delivery.
+func (s UserSettings) cacheQuality(format, quality string) string {
+	s = s.withDefaults()
+	if format == "audio" && s.AudioFormat != "mp3" {
+		quality = s.AudioFormat + "-" + quality
+	}
+	if format == "video" && s.VideoFormat != "mp4" {
+		quality = s.VideoFormat + "-" + quality
+	}
+	if s.AsDocument {
+		quality += "-doc"
+	}
+	return quality
+}
+
+// outputFormat is the audio format or video container to download format in.
+func (s UserSettings) outputFormat(format string) string {
+	s = s.withDefaults()
+	if format == "video" {
+		return s.VideoFormat
+	}
+	return s.AudioFormat
+}
+
+// caption renders the caption of a delivered file in the user's style.
+func (s UserSettings) caption(title, format string) string {
+	switch s.withDefaults().Caption {
+	case "none":
+		return ""
+	case "title":
+		return title
+	}
+	if title == "" {
+		if format == "video" {
+			return "✅ Here's your video!"
+		}
+		return "✅ Here's your audio!"
+	}
+	return fmt.Sprintf("✅ %s", title)
+}
+
+// userSettings returns the stored settings for a user, or zero values.
+func (b *Bot) userSettings(userID int64) UserSettings {
+	var s UserSettings
+	if _, err := b.store.Get(bucketSettings, strconv.FormatInt(userID, 10), &s); err != nil {
+		log.Printf("Failed to load settings for user %d: %v", userID, err)
+	}
+	return s
+}
+
+func (b *Bot) saveUserSettings(userID int64, s UserSettings) error {
+	return b.store.Put(bucketSettings, strconv.FormatInt(userID, 10), s, 0)
+}
+
+func choiceLabel(choices []settingChoice, value string) string {
+	for _, c := range choices {
+		if c.value == value {
+			return c.label
+		}
+	}
+	return value
+}
+
+func onOff(v bool) string {
+	if v {
+		return "On"
+	}
+	return "Off"
+}
+
+func qualityChoices(format string) []settingChoice {
+	if format == "audio" {
+		return audioQualityChoices
+	}
+	return videoQualityChoices
+}
+
+// settingsMenu renders the main settings screen.
+func settingsMenu(s UserSettings) (string, tgbotapi.InlineKeyboardMarkup) {
+	s = s.withDefaults()
+	sendAs := "Media"
+	if s.AsDocument {
+		sendAs = "Document"
+	}
+	text := fmt.Sprintf(`⚙️ *Your settings*
+
+Default format: %s
+Default quality: %s
+Audio format: %s
+Video format: %s
+Send files as: %s
+Captions: %s
+Skip the menu: %s`,
+		choiceLabel(formatChoices, s.Format),
+		choiceLabel(qualityChoices(s.Format), s.Quality),
+		choiceLabel(audioFormatChoices, s.AudioFormat),
+		choiceLabel(videoFormatChoices, s.VideoFormat),
+		sendAs,
+		choiceLabel(captionStyles, s.Caption),
+		onOff(s.AutoDownload))
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🎞 Format", "set:format"),
+			tgbotapi.NewInlineKeyboardButtonData("📐 Quality", "set:quality"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🎵 Audio format", "set:audio"),
+			tgbotapi.NewInlineKeyboardButtonData("🎞 Video format", "set:video"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("💬 Captions", "set:caption"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("📎 Send as: "+sendAs, "set:document:toggle"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⚡ Skip the menu: "+onOff(s.AutoDownload), "set:auto:toggle"),
+		),
+	)
+	return text, keyboard
+}
+
+// settingOptions renders the choices of a single setting.
+func settingOptions(s UserSettings, field string) (string, tgbotapi.InlineKeyboardMarkup, bool) {
+	s = s.withDefaults()
+	var title, current string
+	var choices []settingChoice
+	switch field {
+	case "format":
+		title, current, choices = "Default format", s.Format, formatChoices
+	case "quality":
+		title, current, choices = "Default quality", s.Quality, qualityChoices(s.Format)
+	case "audio":
+		title, current, choices = "Audio format", s.AudioFormat, audioFormatChoices
+	case "video":
+		title, current, choices = "Video format", s.VideoFormat, videoFormatChoices
+	case "caption":
+		title, current, choices = "Caption style", s.Caption, captionStyles
+	default:
+		return "", tgbotapi.InlineKeyboardMarkup{}, false
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, c := range choices {
+		label := c.label
+		if c.value == current {
+			label = "✔️ " + label
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("set:%s:%s", field, c.value)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("⬅️ Back", "set")))
+	return fmt.Sprintf("⚙️ *%s*\n\nChoose a value:", title), tgbotapi.NewInlineKeyboardMarkup(rows...), true
+}
+
+// applySetting changes one field; it reports false for unknown fields or values.
+func applySetting(s UserSettings, field, value string) (UserSettings, bool) {
+	valid := func(choices []settingChoice) bool {
+		for _, c := range choices {
+			if c.value == value {
+				return true
+			}
+		}
+		return false
+	}
+	s = s.withDefaults()
+	switch field {
+	case "format":
+		if !valid(formatChoices) {
+			return s, false
+		}
+		if s.Format != value {
+			// Qualities differ between video and audio
+			s.Quality = "best"
+		}
+		s.Format = value
+	case "quality":
+		if !valid(qualityChoices(s.Format)) {
+			return s, false
+		}
+		s.Quality = value
+	case "audio":
+		if !valid(audioFormatChoices) {
+			return s, false
+		}
+		s.AudioFormat = value
+	case "video":
+		if !valid(videoFormatChoices) {
+			return s, false
+		}
+		s.VideoFormat = value
+	case "caption":
+		if !valid(captionStyles) {
+			return s, false
+		}
+		s.Caption = value
+	case "document":
+		s.AsDocument = !s.AsDocument
+	case "auto":
+		s.AutoDownload = !s.AutoDownload
+	default:
+		return s, false
+	}
+	return s, true
+}
+
+// sendSettings posts the settings menu as a new message.
+func (b *Bot) sendSettings(chatID, userID int64) {
+	text, keyboard := settingsMenu(b.userSettings(userID))
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = "Markdown"
+	msg.ReplyMarkup = keyboard
+	b.api.Send(msg)
+}
+
+// handleSettingsCallback serves the "set[:field[:value]]" buttons by editing
+// the settings message in place.
+func (b *Bot) handleSettingsCallback(query *tgbotapi.CallbackQuery) {
+	parts := strings.SplitN(query.Data, ":", 3)
+	userID := query.From.ID
+	s := b.userSettings(userID)
+
+	var text string
+	var keyboard tgbotapi.InlineKeyboardMarkup
+	answer := ""
+	switch len(parts) {
+	case 1:
+		text, keyboard = settingsMenu(s)
+	case 2:
+		var ok bool
+		if text, keyboard, ok = settingOptions(s, parts[1]); !ok {
+			b.api.Request(tgbotapi.NewCallback(query.ID, ""))
+			return
+		}
+	default:
+		updated, ok := applySetting(s, parts[1], parts[2])
+		if !ok {
+			b.api.Request(tgbotapi.NewCallback(query.ID, "❌ Unknown setting"))
+			return
+		}
+		if err := b.saveUserSettings(userID, updated); err != nil {
+			log.Printf("Failed to save settings for user %d: %v", userID, err)
+			b.api.Request(tgbotapi.NewCallback(query.ID, "❌ Could not save your settings"))
+			return
+		}
+		answer = "✅ Saved"
+		text, keyboard = settingsMenu(updated)
+	}
+
+	b.api.Request(tgbotapi.NewCallback(query.ID, answer))
+	edit := tgbotapi.NewEditMessageTextAndMarkup(query.Message.Chat.ID, query.Message.MessageID, text, keyboard)
+	edit.ParseMode = "Markdown"
+	b.api.Send(edit)
+}
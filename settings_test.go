@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSettingsCallbacksSaveChoices(t *testing.T) {
+	b, tg := newTestBot(t, newFakeDownloader())
+
+	for _, data := range []string{"set:format", "set:format:audio", "set:quality:192", "set:audio:m4a", "set:caption:none", "set:document:toggle"} {
+		b.handleCallbackQuery(callback(data))
+	}
+
+	got := b.userSettings(42)
+	want := UserSettings{Format: "audio", Quality: "192", AudioFormat: "m4a", VideoFormat: "mp4", AsDocument: true, Caption: "none"}
+	if got != want {
+		t.Errorf("settings = %+v, want %+v", got, want)
+	}
+
+	edits := tg.find("editMessageText")
+	if len(edits) != 6 {
+		t.Fatalf("got %d edits, want 6", len(edits))
+	}
+	if text := edits[0].Params.Get("text"); !strings.Contains(text, "Default format") {
+		t.Errorf("format options = %q", text)
+	}
+	if text := edits[5].Params.Get("text"); !strings.Contains(text, "Send files as: Document") {
+		t.Errorf("settings menu = %q", text)
+	}
+
+	// Values that don't belong to the setting are rejected
+	b.handleCallbackQuery(callback("set:quality:1080"))
+	if got := b.userSettings(42).Quality; got != "192" {
+		t.Errorf("audio quality changed to %q", got)
+	}
+}
+
+func TestAutoDownloadSkipsMenu(t *testing.T) {
+	dl := newFakeDownloader()
+	link := "https://www.youtube.com/watch?v=aaaaaaaaaaa"
+	dl.addVideo(link, "aaaaaaaaaaa", "My Video")
+	b, tg := newTestBot(t, dl)
+	b.saveUserSettings(7, UserSettings{Format: "audio", Quality: "128", AudioFormat: "m4a", AutoDownload: true, AsDocument: true, Caption: "title"})
+
+	b.handleUpdate(textUpdate(7, link))
+	waitIdle(t, b)
+
+	fetches := dl.fetched()
+	if len(fetches) != 1 {
+		t.Fatalf("got %d fetches, want 1", len(fetches))
+	}
+	if f := fetches[0]; f.Format != "audio" || f.Quality != "128" || f.Audio != "m4a" || !strings.HasSuffix(f.Output, ".m4a") {
+		t.Errorf("fetch = %+v, want audio/128 as m4a", f)
+	}
+	docs := tg.find("sendDocument")
+	if len(docs) != 1 {
+		t.Fatalf("got %d sendDocument calls, want 1", len(docs))
+	}
+	if got := docs[0].Params.Get("caption"); got != "My Video" {
+		t.Errorf("caption = %q", got)
+	}
+}
+
+func TestCaptionStyles(t *testing.T) {
+	for style, want := range map[string]string{"": "✅ Song", "full": "✅ Song", "title": "Song", "none": ""} {
+		if got := (UserSettings{Caption: style}).caption("Song", "audio"); got != want {
+			t.Errorf("caption style %q = %q, want %q", style, got, want)
+		}
+	}
+	if got := (UserSettings{}).caption("", "video"); got != "✅ Here's your video!" {
+		t.Errorf("untitled caption = %q", got)
+	}
+}
+
+// A settings change must not reuse a file_id uploaded with other options.
+func TestSettingsCacheQuality(t *testing.T) {
+	if got := (UserSettings{}).cacheQuality("audio", "best"); got != "best" {
+		t.Errorf("default cache quality = %q", got)
+	}
+	if got := (UserSettings{AudioFormat: "m4a", AsDocument: true}).cacheQuality("audio", "best"); got != "m4a-best-doc" {
+		t.Errorf("cache quality = %q", got)
+	}
+	if got := (UserSettings{AudioFormat: "m4a"}).cacheQuality("video", "720"); got != "720" {
+		t.Errorf("video cache quality = %q", got)
+	}
+}
@@ -97,5 +97,5 @@ echo "To run the bot:"
 echo "  ./yt-bot"
 echo ""
 echo "Or:"
-echo "  go run main.go"
+echo "  go run ."
 echo ""
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// deliverParts sends a file that is over the upload limit as a sequence of
+// parts cut with the MediaProcessor, captioned "Part i/n".
+func (b *Bot) deliverParts(ctx context.Context, chatID int64, filePath string, d delivery, progress func(Progress)) error {
+	limit := b.config().UploadLimit()
+	if progress != nil {
+		progress(Progress{Stage: "splitting", Percent: -1})
+	}
+	parts, err := b.media.Split(ctx, filePath, limit)
+	if err != nil {
+		// Nobody can use the oversized file
+		defer os.Remove(filePath)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("Failed to split %s: %v", filePath, err)
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("❌ File is too large (>%s) and could not be split. Try a lower quality.", formatBytes(limit)))
+		b.api.Send(msg)
+		return fmt.Errorf("%w: %v", errSendFailed, err)
+	}
+	defer removeFiles(parts)
+
+	if progress != nil {
+		progress(Progress{Stage: "uploading", Percent: -1})
+	}
+	for i, part := range parts {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		partNo := fmt.Sprintf(" (Part %d/%d)", i+1, len(parts))
+		pd := d
+		pd.Title += partNo
+		pd.Meta.Title += partNo
+		pd.Meta.Duration = 0 // only known for the whole file
+		log.Printf("Sending part %d/%d: %s", i+1, len(parts), part)
+		if _, err := b.sendFile(chatID, part, pd); err != nil {
+			return fmt.Errorf("%w: %v", errSendFailed, err)
+		}
+	}
+
+	log.Printf("Cleaning up: %s", filePath)
+	os.Remove(filePath)
+	return nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Store buckets
+const (
+	bucketURLs     = "urls"
+	bucketFileIDs  = "file_ids"
+	bucketJobs     = "jobs"
+	bucketSettings = "settings"
+	bucketUsage    = "usage"
+	bucketAccess   = "access"
+	bucketBans     = "bans"
+)
+
+// Store is the bot's persistent key/value storage. Values are JSON encoded
+// and grouped in buckets; a ttl of 0 keeps the value until it is deleted.
+type Store interface {
+	Get(bucket, key string, v interface{}) (bool, error)
+	Put(bucket, key string, v interface{}, ttl time.Duration) error
+	Delete(bucket, key string) error
+	// Keys returns the live keys of a bucket in sorted order.
+	Keys(bucket string) ([]string, error)
+	Close() error
+}
+
+type storeRecord struct {
+	Value   json.RawMessage `json:"v"`
+	Expires int64           `json:"e,omitempty"` // unix seconds, 0 = never
+}
+
+func (r storeRecord) expired(now time.Time) bool {
+	return r.Expires != 0 && now.Unix() >= r.Expires
+}
+
+// fileStore is an embedded Store kept in memory and snapshotted to a single
+// JSON file. Writes are batched and flushed in the background; expired
+// records are evicted periodically.
+type fileStore struct {
+	mu      sync.Mutex
+	path    string
+	buckets map[string]map[string]storeRecord
+	dirty   bool
+	now     func() time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+const (
+	storeFlushInterval = time.Second
+	storeSweepInterval = 10 * time.Minute
+)
+
+// openFileStore loads the store at path, creating it if needed.
+func openFileStore(path string) (*fileStore, error) {
+	s := &fileStore{
+		path:    path,
+		buckets: make(map[string]map[string]storeRecord),
+		now:     time.Now,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	raw, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(raw, &s.buckets); err != nil {
+			return nil, fmt.Errorf("corrupt store %s: %v", path, err)
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+	s.evict(s.now())
+
+	go s.loop()
+	return s, nil
+}
+
+func (s *fileStore) loop() {
+	defer close(s.done)
+	flush := time.NewTicker(storeFlushInterval)
+	defer flush.Stop()
+	sweep := time.NewTicker(storeSweepInterval)
+	defer sweep.Stop()
+
+	for {
+		select {
+		case <-flush.C:
+			s.flush()
+		case <-sweep.C:
+			s.evict(s.now())
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *fileStore) Get(bucket, key string, v interface{}) (bool, error) {
+	s.mu.Lock()
+	rec, ok := s.buckets[bucket][key]
+	now := s.now()
+	s.mu.Unlock()
+
+	if !ok || rec.expired(now) {
+		return false, nil
+	}
+	if err := json.Unmarshal(rec.Value, v); err != nil {
+		return false, fmt.Errorf("decode %s/%s: %v", bucket, key, err)
+	}
+	return true, nil
+}
+
+func (s *fileStore) Put(bucket, key string, v interface{}, ttl time.Duration) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode %s/%s: %v", bucket, key, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec := storeRecord{Value: raw}
+	if ttl > 0 {
+		rec.Expires = s.now().Add(ttl).Unix()
+	}
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = make(map[string]storeRecord)
+	}
+	s.buckets[bucket][key] = rec
+	s.dirty = true
+	return nil
+}
+
+func (s *fileStore) Delete(bucket, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.buckets[bucket][key]; ok {
+		delete(s.buckets[bucket], key)
+		s.dirty = true
+	}
+	return nil
+}
+
+func (s *fileStore) Keys(bucket string) ([]string, error) {
+	s.mu.Lock()
+	now := s.now()
+	keys := make([]string, 0, len(s.buckets[bucket]))
+	for k, rec := range s.buckets[bucket] {
+		if !rec.expired(now) {
+			keys = append(keys, k)
+		}
+	}
+	s.mu.Unlock()
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Close stops background work and writes pending changes.
+func (s *fileStore) Close() error {
+	select {
+	case <-s.stop:
+		return errors.New("store already closed")
+	default:
+	}
+	close(s.stop)
+	<-s.done
+	return s.flush()
+}
+
+// evict drops expired records.
+func (s *fileStore) evict(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := 0
+	for _, records := range s.buckets {
+		for k, rec := range records {
+			if rec.expired(now) {
+				delete(records, k)
+				removed++
+			}
+		}
+	}
+	if removed > 0 {
+		s.dirty = true
+		log.Printf("Store: evicted %d expired records", removed)
+	}
+}
+
+// flush writes the store to disk if anything changed since the last flush.
+func (s *fileStore) flush() error {
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	raw, err := json.Marshal(s.buckets)
+	s.dirty = false
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Write to a temp file first so a crash never leaves a truncated store
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0600); err != nil {
+		log.Printf("Store: write failed: %v", err)
+		s.markDirty()
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		log.Printf("Store: write failed: %v", err)
+		s.markDirty()
+		return err
+	}
+	return nil
+}
+
+func (s *fileStore) markDirty() {
+	s.mu.Lock()
+	s.dirty = true
+	s.mu.Unlock()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStorePersistsAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	s, err := openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put(bucketURLs, "abc", "https://youtu.be/xyz", time.Hour)
+	s.Put(bucketSettings, "42", UserSettings{Format: "audio", Quality: "320"}, 0)
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	s, err = openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	var url string
+	if ok, err := s.Get(bucketURLs, "abc", &url); !ok || err != nil || url != "https://youtu.be/xyz" {
+		t.Errorf("Get url = %q, %v, %v", url, ok, err)
+	}
+	var settings UserSettings
+	if ok, _ := s.Get(bucketSettings, "42", &settings); !ok || settings.Quality != "320" {
+		t.Errorf("Get settings = %+v, %v", settings, ok)
+	}
+}
+
+func TestFileStoreTTL(t *testing.T) {
+	s, err := openFileStore(filepath.Join(t.TempDir(), "store.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	s.Put(bucketURLs, "short", "a", time.Minute)
+	s.Put(bucketURLs, "forever", "b", 0)
+
+	now = now.Add(2 * time.Minute)
+	var v string
+	if ok, _ := s.Get(bucketURLs, "short", &v); ok {
+		t.Errorf("expired record is still readable")
+	}
+	if keys, _ := s.Keys(bucketURLs); len(keys) != 1 || keys[0] != "forever" {
+		t.Errorf("Keys = %v, want [forever]", keys)
+	}
+
+	s.evict(now)
+	if n := len(s.buckets[bucketURLs]); n != 1 {
+		t.Errorf("%d records left after eviction, want 1", n)
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maxSubtitleLanguages caps the number of language buttons in the subtitle menu.
+const maxSubtitleLanguages = 12
+
+// subtitleModes are the ways a subtitle track can be delivered.
+var subtitleModes = []struct{ Mode, Label string }{
+	{"srt", "📄 SRT file"},
+	{"vtt", "📄 VTT file"},
+	{"soft", "🎬 MP4 with subtitle track"},
+	{"burn", "🔥 MP4 with burned-in subtitles"},
+}
+
+// subtitleRequest is a subtitle language and how to deliver it.
+type subtitleRequest struct {
+	Lang string // language code as yt-dlp lists it, e.g. "en" or "en-orig"
+	Auto bool   // an automatic caption rather than an uploaded subtitle
+	Mode string // see subtitleModes; "" while only the language is chosen
+}
+
+// subtitleLanguages lists the subtitles of a video followed by its automatic
+// captions, at most maxSubtitleLanguages.
+func subtitleLanguages(info *VideoInfo) []subtitleRequest {
+	var langs []subtitleRequest
+	for _, lang := range sortedLanguages(info.Subtitles) {
+		// yt-dlp lists a stream's chat replay as a subtitle
+		if lang != "live_chat" {
+			langs = append(langs, subtitleRequest{Lang: lang})
+		}
+	}
+	// YouTube machine-translates captions into every language; only the
+	// ones of the original speech are worth offering
+	var auto []string
+	for _, lang := range sortedLanguages(info.AutomaticCaptions) {
+		if strings.HasSuffix(lang, "-orig") {
+			auto = append(auto, lang)
+		}
+	}
+	if _, ok := info.AutomaticCaptions["en"]; ok && len(auto) == 0 {
+		auto = []string{"en"}
+	}
+	for _, lang := range auto {
+		langs = append(langs, subtitleRequest{Lang: lang, Auto: true})
+	}
+	if len(langs) > maxSubtitleLanguages {
+		langs = langs[:maxSubtitleLanguages]
+	}
+	return langs
+}
+
+func sortedLanguages(tracks map[string][]SubtitleTrack) []string {
+	langs := make([]string, 0, len(tracks))
+	for lang := range tracks {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// tracks returns the video's files for the language, nil when it has none.
+func (s subtitleRequest) tracks(info *VideoInfo) []SubtitleTrack {
+	if s.Auto {
+		return info.AutomaticCaptions[s.Lang]
+	}
+	return info.Subtitles[s.Lang]
+}
+
+// label names the language for menus and captions, e.g. "English (auto-generated)".
+func (s subtitleRequest) label(info *VideoInfo) string {
+	name := s.Lang
+	for _, t := range s.tracks(info) {
+		if t.Name != "" {
+			name = t.Name
+			break
+		}
+	}
+	if s.Auto {
+		name += " (auto-generated)"
+	}
+	return name
+}
+
+// kind is the callback token for Auto: "a" for captions, "s" for subtitles.
+func (s subtitleRequest) kind() string {
+	if s.Auto {
+		return "a"
+	}
+	return "s"
+}
+
+// embeds reports whether the subtitles go into a video instead of a file of their own.
+func (s subtitleRequest) embeds() bool {
+	return s.Mode == "soft" || s.Mode == "burn"
+}
+
+// fileExt is the format the subtitles are written in. Tracks to burn in
+// are written as SRT too.
+func (s subtitleRequest) fileExt() string {
+	if s.Mode == "vtt" {
+		return "vtt"
+	}
+	return "srt"
+}
+
+// cacheQuality adds the subtitles to the quality part of a file_id key.
+func (s *subtitleRequest) cacheQuality(quality string) string {
+	if s == nil {
+		return quality
+	}
+	return fmt.Sprintf("%s-subs-%s-%s%s", quality, s.Mode, s.kind(), s.Lang)
+}
+
+// subtitlePath is where yt-dlp writes the subtitles of the download at
+// output: "<name>.<lang>.<ext>".
+func subtitlePath(output, lang, ext string) string {
+	return strings.TrimSuffix(output, filepath.Ext(output)) + "." + lang + "." + ext
+}
+
+// handleSubtitleCallback handles the subtitle buttons: "subs:urlID" opens
+// the language menu, "subl:kind:lang:urlID" the delivery options and
+// "sub:mode:kind:lang:urlID" starts the job.
+func (b *Bot) handleSubtitleCallback(query *tgbotapi.CallbackQuery, parts []string) {
+	urlID := parts[len(parts)-1]
+	url, _, _ := splitClip(b.getURLFromCache(urlID))
+	if url == "" {
+		b.api.Request(tgbotapi.NewCallback(query.ID, "❌ Link expired. Please send the link again."))
+		return
+	}
+	chatID := query.Message.Chat.ID
+
+	switch {
+	case parts[0] == "subs" && len(parts) == 2:
+		if reply, ok := b.checkLimits(query.From.ID, false); !ok {
+			b.api.Request(tgbotapi.NewCallbackWithAlert(query.ID, reply))
+			return
+		}
+		b.api.Request(tgbotapi.NewCallback(query.ID, "Looking up subtitles..."))
+		// The lookup may run yt-dlp, so keep it off the update loop
+		go b.sendSubtitleMenu(chatID, url, urlID)
+
+	case parts[0] == "subl" && len(parts) == 4:
+		s := subtitleRequest{Lang: parts[2], Auto: parts[1] == "a"}
+		b.api.Request(tgbotapi.NewCallback(query.ID, ""))
+		var rows [][]tgbotapi.InlineKeyboardButton
+		for _, m := range subtitleModes {
+			data := fmt.Sprintf("sub:%s:%s:%s:%s", m.Mode, s.kind(), s.Lang, urlID)
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(m.Label, data)))
+		}
+		// Swap the language buttons for the delivery options
+		text := fmt.Sprintf("📝 Subtitles: %s\n\nHow would you like them?", pressedLabel(query, s.Lang))
+		b.api.Send(tgbotapi.NewEditMessageTextAndMarkup(chatID, query.Message.MessageID, text, tgbotapi.NewInlineKeyboardMarkup(rows...)))
+
+	case parts[0] == "sub" && len(parts) == 5:
+		s := &subtitleRequest{Mode: parts[1], Lang: parts[3], Auto: parts[2] == "a"}
+		if !validSubtitleMode(s.Mode) {
+			return
+		}
+		if reply, ok := b.checkLimits(query.From.ID, true); !ok {
+			b.api.Request(tgbotapi.NewCallbackWithAlert(query.ID, reply))
+			return
+		}
+		job := &Job{ChatID: chatID, UserID: query.From.ID, URL: url, Format: "subtitles", Quality: "best", Subtitles: s}
+		if s.embeds() {
+			job.Format = "video"
+			if settings := b.userSettings(query.From.ID).withDefaults(); settings.Format == "video" {
+				job.Quality = settings.Quality
+			}
+		}
+		b.enqueueJob(query, job)
+	}
+}
+
+func validSubtitleMode(mode string) bool {
+	for _, m := range subtitleModes {
+		if m.Mode == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// pressedLabel is the text of the button that sent query, or fallback.
+func pressedLabel(query *tgbotapi.CallbackQuery, fallback string) string {
+	if query.Message == nil || query.Message.ReplyMarkup == nil {
+		return fallback
+	}
+	for _, row := range query.Message.ReplyMarkup.InlineKeyboard {
+		for _, button := range row {
+			if button.CallbackData != nil && *button.CallbackData == query.Data {
+				return button.Text
+			}
+		}
+	}
+	return fallback
+}
+
+// sendSubtitleMenu posts the subtitle languages of a video.
+func (b *Bot) sendSubtitleMenu(chatID int64, url, urlID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	info, err := b.videoInfo(ctx, url)
+	if err != nil {
+		log.Printf("Subtitle lookup failed for %s: %v", url, err)
+		b.api.Send(tgbotapi.NewMessage(chatID, "❌ Could not look up the subtitles of this video."))
+		return
+	}
+	langs := subtitleLanguages(info)
+	if len(langs) == 0 {
+		b.api.Send(tgbotapi.NewMessage(chatID, "📝 This video has no subtitles or captions."))
+		return
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var row []tgbotapi.InlineKeyboardButton
+	for _, s := range langs {
+		data := fmt.Sprintf("subl:%s:%s:%s", s.kind(), s.Lang, urlID)
+		// Telegram rejects callback data over 64 bytes; allow for the longest mode
+		if len(data)+len("sub:burn:") > 64 {
+			continue
+		}
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(s.label(info), data))
+		if len(row) == 2 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+	text := fmt.Sprintf("📝 *Subtitles for %s*\n\nChoose a language:", tgbotapi.EscapeText(tgbotapi.ModeMarkdown, info.Title))
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = "Markdown"
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	b.api.Send(msg)
+}
+
+// deliverSubtitles downloads a subtitle track and sends it as a document.
+func (b *Bot) deliverSubtitles(ctx context.Context, chatID, userID int64, req mediaRequest, progress func(Progress)) error {
+	info, err := b.videoInfo(ctx, req.URL)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	s := req.Subtitles
+	path, err := b.downloadSubtitles(ctx, req.URL, info, s, progress)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+
+	if progress != nil {
+		progress(Progress{Stage: "uploading", Percent: -1})
+	}
+	file, err := b.uploadFile(path)
+	if err != nil {
+		return err
+	}
+	doc := tgbotapi.NewDocument(chatID, file)
+	doc.Caption = b.userSettings(userID).caption(info.Title+"\n📝 "+s.label(info), "video")
+	if _, err := b.api.Send(doc); err != nil {
+		log.Printf("Failed to send subtitles: %v", err)
+		return fmt.Errorf("%w: %v", errSendFailed, err)
+	}
+	return nil
+}
+
+// downloadSubtitles fetches only the subtitle track s of a video, in the
+// format of s.Mode, and returns the path of the file.
+func (b *Bot) downloadSubtitles(ctx context.Context, url string, info *VideoInfo, s *subtitleRequest, progress func(Progress)) (string, error) {
+	if len(s.tracks(info)) == 0 {
+		return "", fmt.Errorf("this video has no %s subtitles", s.Lang)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+	output := filepath.Join(b.downloadPath, fmt.Sprintf("%s - %s.%s", sanitizeFilename(info.Title), info.ID, s.fileExt()))
+	path := subtitlePath(output, s.Lang, s.fileExt())
+	err := b.dl.Fetch(ctx, FetchRequest{
+		URL:       url,
+		Format:    "subtitles",
+		Subtitles: s,
+		Output:    output,
+		Info:      info,
+		Progress:  progress,
+	})
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("subtitles file not found: %s", filepath.Base(path))
+	}
+	return path, nil
+}
+
+// burnSubtitles renders a subtitle file into a downloaded video and returns
+// the path of the file to send.
+func (b *Bot) burnSubtitles(ctx context.Context, filePath, subtitles string, progress func(Progress)) (string, error) {
+	if !b.media.Available() {
+		return filePath, fmt.Errorf("ffmpeg is not installed")
+	}
+	if progress != nil {
+		progress(Progress{Stage: "burning in subtitles", Percent: -1})
+	}
+	burned, err := b.media.BurnSubtitles(ctx, filePath, subtitles)
+	if err != nil {
+		return filePath, err
+	}
+	os.Remove(filePath)
+	return burned, nil
+}
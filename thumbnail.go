@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// bestThumbnail is the video's largest thumbnail. Among equal sizes, or
+// when none has dimensions, the later one wins as yt-dlp lists the
+// preferred ones last. It is nil when the video has none listed.
+func bestThumbnail(info *VideoInfo) *Thumbnail {
+	var best *Thumbnail
+	for i := range info.Thumbnails {
+		t := &info.Thumbnails[i]
+		if best == nil || t.Width*t.Height >= best.Width*best.Height {
+			best = t
+		}
+	}
+	return best
+}
+
+// deliverThumbnail sends the largest thumbnail of a video as a photo and,
+// uncompressed, as a document. yt-dlp converts WebP thumbnails to JPG.
+func (b *Bot) deliverThumbnail(ctx context.Context, chatID int64, req mediaRequest, progress func(Progress)) error {
+	info, err := b.videoInfo(ctx, req.URL)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	best := bestThumbnail(info)
+	if best == nil && info.Thumbnail == "" {
+		return fmt.Errorf("this video has no thumbnail")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+	output := filepath.Join(b.downloadPath, fmt.Sprintf("%s - %s.jpg", sanitizeFilename(info.Title), info.ID))
+	path := thumbnailPath(output)
+	defer os.Remove(path)
+	err = b.dl.Fetch(ctx, FetchRequest{
+		URL:       req.URL,
+		Format:    "thumbnail",
+		Thumbnail: true,
+		Output:    output,
+		Info:      info,
+		Progress:  progress,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("thumbnail file not found: %s", filepath.Base(path))
+	}
+
+	if progress != nil {
+		progress(Progress{Stage: "uploading", Percent: -1})
+	}
+	caption := "🖼 " + info.Title
+	if best != nil && best.Width > 0 {
+		caption += fmt.Sprintf("\n%d×%d", best.Width, best.Height)
+	}
+	file, err := b.uploadFile(path)
+	if err != nil {
+		return err
+	}
+	// Telegram recompresses photos; the document keeps the original
+	photo := tgbotapi.NewPhoto(chatID, file)
+	photo.Caption = caption
+	if _, err := b.api.Send(photo); err != nil {
+		log.Printf("Failed to send thumbnail as photo: %v", err)
+	}
+	doc := tgbotapi.NewDocument(chatID, file)
+	doc.Caption = "🖼 Full resolution, uncompressed"
+	if _, err := b.api.Send(doc); err != nil {
+		log.Printf("Failed to send thumbnail: %v", err)
+		return fmt.Errorf("%w: %v", errSendFailed, err)
+	}
+	return nil
+}
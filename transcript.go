@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// transcriptMessageLimit is the longest transcript sent as a message; longer
+// ones arrive as a .txt file. Telegram allows 4096 characters per message.
+const transcriptMessageLimit = 3500
+
+// Paragraphs end at a pause in the speech, after a sentence once they are
+// transcriptParagraph characters long, or at twice that regardless.
+const (
+	transcriptPause     = 3.0 // seconds
+	transcriptParagraph = 400
+)
+
+// transcriptCue is a line of captions and when it is shown.
+type transcriptCue struct {
+	Start, End float64
+	Text       string
+}
+
+var (
+	vttTimingRegex = regexp.MustCompile(`^(\S+)\s+-->\s+(\S+)`)
+	vttTagRegex    = regexp.MustCompile(`<[^>]*>`)
+)
+
+// parseVTT reads the caption lines of a WebVTT file without timing, styling
+// and the lines YouTube's rolling auto-captions repeat from earlier cues.
+func parseVTT(data string) []transcriptCue {
+	var cues []transcriptCue
+	var recent []string // the last lines kept, to drop rolled-over repeats
+	var start, end float64
+	inCue := false
+	for _, line := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if m := vttTimingRegex.FindStringSubmatch(line); m != nil {
+			start, end = parseVTTTime(m[1]), parseVTTTime(m[2])
+			inCue = true
+			continue
+		}
+		if line == "" {
+			inCue = false
+			continue
+		}
+		// The header, NOTE and STYLE blocks and cue identifiers
+		if !inCue {
+			continue
+		}
+		text := html.UnescapeString(vttTagRegex.ReplaceAllString(line, ""))
+		text = strings.Join(strings.Fields(text), " ")
+		if text == "" || containsString(recent, text) {
+			continue
+		}
+		recent = append(recent, text)
+		if len(recent) > 2 {
+			recent = recent[1:]
+		}
+		cues = append(cues, transcriptCue{Start: start, End: end, Text: text})
+	}
+	return cues
+}
+
+// parseVTTTime reads a cue time, "mm:ss.ttt" or "hh:mm:ss.ttt".
+func parseVTTTime(s string) float64 {
+	var seconds float64
+	for _, part := range strings.Split(s, ":") {
+		n, _ := strconv.ParseFloat(part, 64)
+		seconds = seconds*60 + n
+	}
+	return seconds
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// transcriptText joins caption lines into paragraphs, each starting with
+// its time when timestamps is set.
+func transcriptText(cues []transcriptCue, timestamps bool) string {
+	var paragraphs []string
+	var lines []string
+	var start, lastEnd float64
+	length := 0
+	flush := func() {
+		if len(lines) == 0 {
+			return
+		}
+		p := strings.Join(lines, " ")
+		if timestamps {
+			p = fmt.Sprintf("[%s] %s", formatDuration(start), p)
+		}
+		paragraphs = append(paragraphs, p)
+		lines, length = nil, 0
+	}
+	for _, c := range cues {
+		if len(lines) > 0 {
+			last := lines[len(lines)-1]
+			sentence := strings.ContainsAny(last[len(last)-1:], ".?!")
+			if c.Start-lastEnd >= transcriptPause || length >= 2*transcriptParagraph || (length >= transcriptParagraph && sentence) {
+				flush()
+			}
+		}
+		if len(lines) == 0 {
+			start = c.Start
+		}
+		lines = append(lines, c.Text)
+		length += len(c.Text) + 1
+		lastEnd = c.End
+	}
+	flush()
+	return strings.Join(paragraphs, "\n\n")
+}
+
+// transcriptLanguage picks the captions a transcript is made from: uploaded
+// subtitles before auto-captions, in the video's language, else English,
+// else the first available.
+func transcriptLanguage(info *VideoInfo) (subtitleRequest, bool) {
+	langs := subtitleLanguages(info)
+	if len(langs) == 0 {
+		return subtitleRequest{}, false
+	}
+	// Only the best kind available is considered; uploaded ones come first
+	var candidates []subtitleRequest
+	for _, s := range langs {
+		if s.Auto == langs[0].Auto {
+			candidates = append(candidates, s)
+		}
+	}
+	for _, want := range []string{info.Language, "en"} {
+		for _, s := range candidates {
+			if want != "" && baseLanguage(s.Lang) == baseLanguage(want) {
+				return s, true
+			}
+		}
+	}
+	return candidates[0], true
+}
+
+// baseLanguage strips region and suffixes from a code: "en-US-orig" is "en".
+func baseLanguage(lang string) string {
+	base, _, _ := strings.Cut(lang, "-")
+	return strings.ToLower(base)
+}
+
+// handleTranscriptCommand handles "/transcript <url> [timestamps]".
+func (b *Bot) handleTranscriptCommand(message *tgbotapi.Message) {
+	args := strings.Fields(message.CommandArguments())
+	if len(args) == 0 || b.detectPlatform(args[0]) != "youtube" {
+		b.api.Send(tgbotapi.NewMessage(message.Chat.ID, "🧾 Send a YouTube video link with the command, e.g.\n/transcript https://youtu.be/ID\n\nAdd \"timestamps\" to start each paragraph with its time."))
+		return
+	}
+	timestamps := len(args) > 1 && strings.HasPrefix(strings.ToLower(args[1]), "time")
+	if message.From == nil {
+		return
+	}
+	if reply, ok := b.checkLimits(message.From.ID, true); !ok {
+		b.api.Send(tgbotapi.NewMessage(message.Chat.ID, reply))
+		return
+	}
+	b.enqueueJob(nil, &Job{
+		ChatID:     message.Chat.ID,
+		UserID:     message.From.ID,
+		URL:        args[0],
+		Format:     "transcript",
+		Quality:    "best",
+		Transcript: true,
+		Timestamps: timestamps,
+	})
+}
+
+// handleTranscriptCallback handles the transcript buttons of the quality
+// menu: "tr:p:urlID" for plain text, "tr:t:urlID" with timestamps.
+func (b *Bot) handleTranscriptCallback(query *tgbotapi.CallbackQuery, parts []string) {
+	if len(parts) != 3 {
+		return
+	}
+	url, _, _ := splitClip(b.getURLFromCache(parts[2]))
+	if url == "" {
+		b.api.Request(tgbotapi.NewCallback(query.ID, "❌ Link expired. Please send the link again."))
+		return
+	}
+	if reply, ok := b.checkLimits(query.From.ID, true); !ok {
+		b.api.Request(tgbotapi.NewCallbackWithAlert(query.ID, reply))
+		return
+	}
+	b.enqueueJob(query, &Job{
+		ChatID:     query.Message.Chat.ID,
+		UserID:     query.From.ID,
+		URL:        url,
+		Format:     "transcript",
+		Quality:    "best",
+		Transcript: true,
+		Timestamps: parts[1] == "t",
+	})
+}
+
+// deliverTranscript sends the captions of a video as plain text: in a
+// message when short, else as a .txt document.
+func (b *Bot) deliverTranscript(ctx context.Context, chatID int64, req mediaRequest, timestamps bool, progress func(Progress)) error {
+	info, err := b.videoInfo(ctx, req.URL)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	s, ok := transcriptLanguage(info)
+	if !ok {
+		return fmt.Errorf("this video has no subtitles or captions to transcribe")
+	}
+	s.Mode = "vtt"
+	path, err := b.downloadSubtitles(ctx, req.URL, info, &s, progress)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	text := transcriptText(parseVTT(string(data)), timestamps)
+	if text == "" {
+		return fmt.Errorf("the %s captions of this video are empty", s.label(info))
+	}
+
+	header := fmt.Sprintf("🧾 %s\n📝 %s", info.Title, s.label(info))
+	if len(header)+2+len(text) <= transcriptMessageLimit {
+		if _, err := b.api.Send(tgbotapi.NewMessage(chatID, header+"\n\n"+text)); err != nil {
+			return fmt.Errorf("%w: %v", errSendFailed, err)
+		}
+		return nil
+	}
+
+	txt := filepath.Join(b.downloadPath, fmt.Sprintf("%s - %s transcript.txt", sanitizeFilename(info.Title), info.ID))
+	content := fmt.Sprintf("%s\n%s\n%s\n\n%s\n", info.Title, info.WebpageURL, s.label(info), text)
+	if err := os.WriteFile(txt, []byte(content), 0644); err != nil {
+		return err
+	}
+	defer os.Remove(txt)
+	if progress != nil {
+		progress(Progress{Stage: "uploading", Percent: -1})
+	}
+	file, err := b.uploadFile(txt)
+	if err != nil {
+		return err
+	}
+	doc := tgbotapi.NewDocument(chatID, file)
+	doc.Caption = header
+	if _, err := b.api.Send(doc); err != nil {
+		log.Printf("Failed to send transcript: %v", err)
+		return fmt.Errorf("%w: %v", errSendFailed, err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Auto-captions as YouTube serves them: word timing tags and every line
+// repeated by the cue after it.
+const rollingVTT = `WEBVTT
+Kind: captions
+Language: en
+
+00:00:00.000 --> 00:00:02.000 align:start position:0%
+hello<00:00:00.500><c> everyone</c><00:00:01.000><c> and</c>
+
+00:00:02.000 --> 00:00:02.010 align:start position:0%
+hello everyone and
+
+00:00:02.010 --> 00:00:04.000 align:start position:0%
+hello everyone and
+welcome to the talk.
+
+00:00:04.000 --> 00:00:04.010 align:start position:0%
+welcome to the talk.
+
+00:00:09.000 --> 00:00:11.000 align:start position:0%
+welcome to the talk.
+Tom &amp; Jerry are next
+`
+
+func TestParseVTT(t *testing.T) {
+	cues := parseVTT(rollingVTT)
+	var lines []string
+	for _, c := range cues {
+		lines = append(lines, c.Text)
+	}
+	want := []string{"hello everyone and", "welcome to the talk.", "Tom & Jerry are next"}
+	if strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Errorf("lines = %q, want %q", lines, want)
+	}
+	if cues[2].Start != 9 {
+		t.Errorf("third line starts at %v, want 9", cues[2].Start)
+	}
+}
+
+func TestTranscriptText(t *testing.T) {
+	cues := parseVTT(rollingVTT)
+	// The pause before 0:09 starts a new paragraph
+	if got := transcriptText(cues, false); got != "hello everyone and welcome to the talk.\n\nTom & Jerry are next" {
+		t.Errorf("transcript = %q", got)
+	}
+	if got := transcriptText(cues, true); got != "[0:00] hello everyone and welcome to the talk.\n\n[0:09] Tom & Jerry are next" {
+		t.Errorf("transcript with timestamps = %q", got)
+	}
+}
+
+func TestTranscriptLanguage(t *testing.T) {
+	info := &VideoInfo{
+		Language:          "de",
+		Subtitles:         map[string][]SubtitleTrack{"en": {{Ext: "vtt"}}, "de-DE": {{Ext: "vtt"}}},
+		AutomaticCaptions: map[string][]SubtitleTrack{"de-orig": {{Ext: "vtt"}}},
+	}
+	if s, ok := transcriptLanguage(info); !ok || s.Lang != "de-DE" || s.Auto {
+		t.Errorf("language = %+v, want the uploaded German subtitles", s)
+	}
+
+	info.Subtitles = nil
+	if s, ok := transcriptLanguage(info); !ok || s.Lang != "de-orig" || !s.Auto {
+		t.Errorf("language = %+v, want the German auto-captions", s)
+	}
+
+	info.AutomaticCaptions = nil
+	if _, ok := transcriptLanguage(info); ok {
+		t.Errorf("found captions in a video without any")
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func loadUpdates(t *testing.T, path string) []tgbotapi.Update {
+	t.Helper()
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var updates []tgbotapi.Update
+	if err := json.Unmarshal(raw, &updates); err != nil {
+		t.Fatalf("decode %s: %v", path, err)
+	}
+	return updates
+}
+
+// TestReplayUpdateStream feeds a recorded update stream through the real
+// polling loop and checks what the bot sent back.
+func TestReplayUpdateStream(t *testing.T) {
+	dl := newFakeDownloader()
+	dl.addVideo("https://www.youtube.com/watch?v=dQw4w9WgXcQ", "dQw4w9WgXcQ", "Never Gonna Give You Up")
+	b, tg := newTestBot(t, dl)
+	tg.pushUpdates(loadUpdates(t, "testdata/updates.json")...)
+
+	api := b.api.(*tgbotapi.BotAPI)
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = 1
+	done := make(chan struct{})
+	go func() {
+		b.handleUpdates(api.GetUpdatesChan(u))
+		close(done)
+	}()
+
+	videos := tg.waitFor(t, "sendVideo", 1)
+	api.StopReceivingUpdates()
+	<-done
+	waitIdle(t, b)
+
+	if got := videos[0].Params.Get("caption"); got != "✅ Never Gonna Give You Up" {
+		t.Errorf("video caption = %q", got)
+	}
+	if files := strings.Join(videos[0].Files, " "); !strings.Contains(files, "video") {
+		t.Errorf("video should be uploaded as a file, got files %v", videos[0].Files)
+	}
+
+	// /start sends the welcome photo
+	if photos := tg.find("sendPhoto"); len(photos) != 1 || !strings.Contains(photos[0].Params.Get("caption"), "Welcome") {
+		t.Errorf("welcome photo not sent: %+v", photos)
+	}
+
+	var texts []string
+	for _, m := range tg.find("sendMessage") {
+		texts = append(texts, m.Params.Get("text"))
+	}
+	want := []string{
+		"Please send a valid YouTube video or playlist link.",
+		"🔎 Looking up available formats...",
+	}
+	for _, w := range want {
+		var found bool
+		for _, text := range texts {
+			if strings.HasPrefix(text, w) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("no message starting with %q in %q", w, texts)
+		}
+	}
+
+	// The placeholder is replaced by the quality menu
+	tg.waitForText(t, "editMessageText", "📥 *Choose quality:*")
+
+	answers := tg.find("answerCallbackQuery")
+	if len(answers) != 1 || answers[0].Params.Get("callback_query_id") != "cbq-1" {
+		t.Errorf("callback was not answered: %+v", answers)
+	}
+	if fetches := dl.fetched(); len(fetches) != 1 || fetches[0].Quality != "720" {
+		t.Errorf("fetches = %+v, want one 720p download", fetches)
+	}
+}
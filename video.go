@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// videoContainer is a video output the bot can produce.
+type videoContainer struct {
+	Name  string // value used in settings and callbacks
+	Label string
+	Ext   string // extension of the downloaded file, also --merge-output-format
+	// yt-dlp filters for the separate video and audio streams and for a
+	// single file that has both, e.g. "[ext=mp4]"
+	VideoFilter, AudioFilter, MuxedFilter string
+	// Compatible guarantees H.264 + AAC in MP4, converting with ffmpeg
+	// when the downloaded streams are anything else.
+	Compatible bool
+}
+
+var videoContainers = []videoContainer{
+	{Name: "mp4", Label: "MP4", Ext: "mp4", VideoFilter: "[ext=mp4]", AudioFilter: "[ext=m4a]", MuxedFilter: "[ext=mp4]"},
+	{Name: "webm", Label: "WebM (VP9/AV1)", Ext: "webm", VideoFilter: "[ext=webm]", AudioFilter: "[ext=webm]", MuxedFilter: "[ext=webm]"},
+	{Name: "mkv", Label: "MKV (best streams)", Ext: "mkv"},
+	{Name: "compat", Label: "Max compatibility (H.264)", Ext: "mp4", VideoFilter: "[vcodec^=avc1]", AudioFilter: "[acodec^=mp4a]", MuxedFilter: "[vcodec^=avc1][acodec^=mp4a]", Compatible: true},
+}
+
+// lookupVideoContainer finds a container by name; unknown names get MP4.
+func lookupVideoContainer(name string) (videoContainer, bool) {
+	for _, c := range videoContainers {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return videoContainers[0], false
+}
+
+// selector builds the yt-dlp format selector for a menu quality: the best
+// separate streams in this container, else the best single file, else
+// anything.
+func (c videoContainer) selector(quality string) string {
+	height := ""
+	if quality != "best" {
+		// Any resolution offered by the quality menu, e.g. "1440" or "240"
+		h, err := strconv.Atoi(quality)
+		if err != nil || h <= 0 {
+			return strings.TrimPrefix(fmt.Sprintf("best%s/best", c.MuxedFilter), "best/")
+		}
+		height = fmt.Sprintf("[height<=%d]", h)
+	}
+	choices := []string{
+		fmt.Sprintf("bestvideo%s%s+bestaudio%s", height, c.VideoFilter, c.AudioFilter),
+		"best" + height + c.MuxedFilter,
+	}
+	if height+c.MuxedFilter != "" {
+		choices = append(choices, "best")
+	}
+	return strings.Join(choices, "/")
+}
+
+// matches reports whether a stream passes one of the container's filters,
+// mirroring what yt-dlp would select. Only the filters used above are known.
+func (c videoContainer) matches(f Format, filter string) bool {
+	for _, cond := range strings.SplitAfter(filter, "]") {
+		cond = strings.Trim(cond, "[]")
+		switch {
+		case cond == "":
+		case strings.HasPrefix(cond, "ext="):
+			if f.Ext != strings.TrimPrefix(cond, "ext=") {
+				return false
+			}
+		case strings.HasPrefix(cond, "vcodec^="):
+			if !strings.HasPrefix(f.VCodec, strings.TrimPrefix(cond, "vcodec^=")) {
+				return false
+			}
+		case strings.HasPrefix(cond, "acodec^="):
+			if !strings.HasPrefix(f.ACodec, strings.TrimPrefix(cond, "acodec^=")) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// makeCompatible converts a max-compatibility download to H.264/AAC when
+// needed and returns the path of the file to send.
+func (b *Bot) makeCompatible(ctx context.Context, filePath string, progress func(Progress)) (string, error) {
+	if !b.media.Available() {
+		return filePath, fmt.Errorf("ffmpeg is not installed")
+	}
+	if progress != nil {
+		progress(Progress{Stage: "converting", Percent: -1})
+	}
+	converted, err := b.media.MakeCompatible(ctx, filePath)
+	if err != nil {
+		return filePath, err
+	}
+	os.Remove(filePath)
+	return converted, nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// VideoInfo is the metadata yt-dlp reports for a single video (`yt-dlp -J`).
+// It is fetched once per request and shared by the menus and the download.
+type VideoInfo struct {
+	ID          string      `json:"id"`
+	Title       string      `json:"title"`
+	Uploader    string      `json:"uploader"`
+	Channel     string      `json:"channel"`
+	Artist      string      `json:"artist"` // set for music, like Track and Album
+	Track       string      `json:"track"`
+	Album       string      `json:"album"`
+	UploadDate  string      `json:"upload_date"` // YYYYMMDD
+	Language    string      `json:"language"`    // spoken language, e.g. "en"
+	Duration    float64     `json:"duration"`    // seconds
+	WebpageURL  string      `json:"webpage_url"`
+	Thumbnail   string      `json:"thumbnail"`
+	Thumbnails  []Thumbnail `json:"thumbnails"`
+	Formats     []Format    `json:"formats"`
+	Chapters    []Chapter   `json:"chapters"`
+	Filesize    int64       `json:"filesize"`
+	FilesizeEst int64       `json:"filesize_approx"`
+	// Subtitles and AutomaticCaptions are keyed by language code
+	Subtitles         map[string][]SubtitleTrack `json:"subtitles"`
+	AutomaticCaptions map[string][]SubtitleTrack `json:"automatic_captions"`
+
+	// raw is the JSON document as printed by yt-dlp, handed back to it with
+	// --load-info-json so the download does not extract the page again.
+	raw json.RawMessage
+}
+
+// Thumbnail is one of the preview images of a video.
+type Thumbnail struct {
+	ID     string `json:"id"`
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// Format is one downloadable stream of a video.
+type Format struct {
+	FormatID       string  `json:"format_id"`
+	Ext            string  `json:"ext"`
+	VCodec         string  `json:"vcodec"`
+	ACodec         string  `json:"acodec"`
+	Width          int     `json:"width"`
+	Height         int     `json:"height"`
+	FPS            float64 `json:"fps"`
+	TBR            float64 `json:"tbr"` // total bitrate, kbit/s
+	ABR            float64 `json:"abr"` // audio bitrate, kbit/s
+	Filesize       int64   `json:"filesize"`
+	FilesizeApprox int64   `json:"filesize_approx"`
+	Protocol       string  `json:"protocol"`
+}
+
+// Chapter is a named section of a video.
+type Chapter struct {
+	Title     string  `json:"title"`
+	StartTime float64 `json:"start_time"`
+	EndTime   float64 `json:"end_time"`
+}
+
+// SubtitleTrack is one file format of a subtitle language.
+type SubtitleTrack struct {
+	Ext  string `json:"ext"`
+	URL  string `json:"url"`
+	Name string `json:"name"` // language name, e.g. "English"
+}
+
+// parseVideoInfo decodes the output of `yt-dlp -J`.
+func parseVideoInfo(raw []byte) (*VideoInfo, error) {
+	var info VideoInfo
+	if err := json.Unmarshal(raw, &info); err != nil {
+		return nil, err
+	}
+	info.raw = append(json.RawMessage(nil), raw...)
+	return &info, nil
+}
+
+// HasVideo reports whether the format carries a video stream.
+func (f Format) HasVideo() bool {
+	return f.VCodec != "" && f.VCodec != "none"
+}
+
+// HasAudio reports whether the format carries an audio stream.
+func (f Format) HasAudio() bool {
+	return f.ACodec != "" && f.ACodec != "none"
+}
+
+// Size returns the exact or estimated size of the format in bytes, 0 if unknown.
+func (f Format) Size() int64 {
+	if f.Filesize > 0 {
+		return f.Filesize
+	}
+	return f.FilesizeApprox
+}
+
+// infoCacheTTL bounds how long fetched metadata is reused. Stream URLs in
+// the info expire after a few hours, so this stays well below that.
+const (
+	infoCacheTTL  = 30 * time.Minute
+	infoCacheSize = 200
+)
+
+type infoCacheEntry struct {
+	info    *VideoInfo
+	fetched time.Time
+}
+
+// infoCache keeps recently fetched VideoInfo keyed by URL, so showing the
+// menu and downloading afterwards costs a single yt-dlp metadata call.
+type infoCache struct {
+	mu      sync.Mutex
+	entries map[string]infoCacheEntry
+}
+
+func newInfoCache() *infoCache {
+	return &infoCache{entries: make(map[string]infoCacheEntry)}
+}
+
+func (c *infoCache) get(url string) *VideoInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[url]
+	if !ok || time.Since(e.fetched) > infoCacheTTL {
+		delete(c.entries, url)
+		return nil
+	}
+	return e.info
+}
+
+func (c *infoCache) put(url string, info *VideoInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= infoCacheSize {
+		// Drop the oldest entry to stay bounded
+		var oldestURL string
+		var oldest time.Time
+		for u, e := range c.entries {
+			if oldestURL == "" || e.fetched.Before(oldest) {
+				oldestURL, oldest = u, e.fetched
+			}
+		}
+		delete(c.entries, oldestURL)
+	}
+	c.entries[url] = infoCacheEntry{info: info, fetched: time.Now()}
+}
+
+// videoInfo returns the metadata for url, fetching it at most once per infoCacheTTL.
+func (b *Bot) videoInfo(ctx context.Context, url string) (*VideoInfo, error) {
+	if info := b.infos.get(url); info != nil {
+		return info, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	info, err := b.dl.Info(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Fetched metadata for %s: id=%s, %d formats, %d chapters", url, info.ID, len(info.Formats), len(info.Chapters))
+	b.infos.put(url, info)
+	return info, nil
+}
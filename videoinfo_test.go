@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func loadInfo(t *testing.T) *VideoInfo {
+	t.Helper()
+	raw, err := os.ReadFile("testdata/info.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := parseVideoInfo(raw)
+	if err != nil {
+		t.Fatalf("parseVideoInfo: %v", err)
+	}
+	return info
+}
+
+func TestParseVideoInfo(t *testing.T) {
+	info := loadInfo(t)
+
+	if info.ID != "dQw4w9WgXcQ" || info.Title != "Never Gonna Give You Up" || info.Uploader != "Rick Astley" {
+		t.Errorf("unexpected basic fields: %+v", info)
+	}
+	if info.Duration != 212 {
+		t.Errorf("Duration = %v", info.Duration)
+	}
+	if len(info.Thumbnails) != 3 || len(info.Chapters) != 3 || info.Chapters[2].Title != "Chorus" {
+		t.Errorf("thumbnails/chapters not decoded: %d, %+v", len(info.Thumbnails), info.Chapters)
+	}
+	if len(info.raw) == 0 {
+		t.Errorf("raw JSON must be kept for --load-info-json")
+	}
+
+	var audioOnly, videoOnly, muxed int
+	for _, f := range info.Formats {
+		switch {
+		case f.HasVideo() && f.HasAudio():
+			muxed++
+		case f.HasVideo():
+			videoOnly++
+		case f.HasAudio():
+			audioOnly++
+		}
+	}
+	if audioOnly != 3 || videoOnly != 5 || muxed != 1 {
+		t.Errorf("format kinds = %d audio, %d video, %d muxed", audioOnly, videoOnly, muxed)
+	}
+	if got := info.Formats[3].Size(); got != 13338620 {
+		t.Errorf("approximate size = %d", got)
+	}
+}
+
+func TestWithInfoFields(t *testing.T) {
+	raw, err := withInfoFields([]byte(`{"id":"x","album":"Original"}`), map[string]interface{}{"album": "Mix", "track_number": 2, "artist": ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	json.Unmarshal(raw, &doc)
+	if doc["album"] != "Mix" || doc["track_number"] != float64(2) || doc["id"] != "x" {
+		t.Errorf("doc = %v", doc)
+	}
+	if _, ok := doc["artist"]; ok {
+		t.Errorf("empty fields must not be set: %v", doc)
+	}
+}